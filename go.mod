@@ -7,5 +7,9 @@ require github.com/go-chi/chi/v5 v5.0.12
 require (
 	github.com/golang-jwt/jwt/v5 v5.2.0
 	github.com/joho/godotenv v1.5.1
+	github.com/mattn/go-sqlite3 v1.14.22
 	golang.org/x/crypto v0.20.0
+	golang.org/x/text v0.14.0
 )
+
+require golang.org/x/sys v0.17.0
@@ -4,16 +4,45 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
-	"strconv"
-	"strings"
+	"time"
 
 	"github.com/yuheng-liu/chirpy/internal/auth"
+	"github.com/yuheng-liu/chirpy/internal/database"
+	"github.com/yuheng-liu/chirpy/internal/moderation"
 )
 
 type Chirp struct {
-	ID       int    `json:"id"`
-	AuthorID int    `json:"author_id"`
-	Body     string `json:"body"`
+	ID        int       `json:"id"`
+	PublicID  string    `json:"public_id,omitempty"`
+	AuthorID  int       `json:"author_id"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// review state, flags and reason are only shown to the author and moderators
+	Status       database.ChirpStatus `json:"status"`
+	Flags        []string             `json:"flags,omitempty"`
+	ReviewReason string               `json:"review_reason,omitempty"`
+}
+
+// convert chirp from db struct to response struct
+func chirpFromDB(dbChirp database.Chirp) Chirp {
+	return Chirp{
+		ID:        dbChirp.ID,
+		PublicID:  dbChirp.PublicID,
+		AuthorID:  dbChirp.AuthorID,
+		Body:      dbChirp.Body,
+		CreatedAt: dbChirp.CreatedAt,
+		UpdatedAt: dbChirp.UpdatedAt,
+		Status:    dbChirp.Status,
+	}
+}
+
+// convert chirp including its review details, for the author and moderators
+func chirpWithReviewFromDB(dbChirp database.Chirp) Chirp {
+	chirp := chirpFromDB(dbChirp)
+	chirp.Flags = dbChirp.Flags
+	chirp.ReviewReason = dbChirp.ReviewReason
+	return chirp
 }
 
 func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request) {
@@ -21,81 +50,65 @@ func (cfg *apiConfig) handlerChirpsCreate(w http.ResponseWriter, r *http.Request
 	type parameters struct {
 		Body string `json:"body"`
 	}
-	// retrieve the jwt from request header
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
+	// user authenticated by the auth middleware
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
 		return
 	}
-	// check if retrieved jwt is valid, get back user ID as subject value
-	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
-		return
-	}
-	// convert user ID to int
-	userID, err := strconv.Atoi(subject)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
-		return
-	}
 	// decoding json to struct and handle error
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
 		return
 	}
 	// filter out unwanted words and length
-	cleaned, err := validateChirp(params.Body)
+	cleaned, flags, err := cfg.validateChirp(params.Body)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	// flagged chirps wait in the moderation queue until reviewed
+	status := database.ChirpStatusApproved
+	if len(flags) > 0 {
+		status = database.ChirpStatusPending
+	}
 	// create chirp and save to db, handle error
-	chirp, err := cfg.DB.CreateChirp(cleaned, userID)
+	chirp, err := cfg.DB.CreateChirp(cleaned, user.ID, status, flags)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp")
 		return
 	}
 	// all checks passed, send response with proper data
-	respondWithJSON(w, http.StatusCreated, Chirp{
-		ID:       chirp.ID,
-		AuthorID: chirp.AuthorID,
-		Body:     chirp.Body,
-	})
+	respondWithJSON(w, http.StatusCreated, chirpWithReviewFromDB(chirp))
 }
 
-func validateChirp(body string) (string, error) {
+// ErrChirpRejected - chirp broke a moderation rule with the reject action
+var ErrChirpRejected = errors.New("Chirp contains prohibited content")
+
+// check the chirp length and run it through the moderation rules, returns the
+// body with masked words replaced and the names of the rules that flagged it
+func (cfg *apiConfig) validateChirp(body string) (string, []string, error) {
 	// check if body length is too long
 	const maxChirpLength = 140
 	if len(body) > maxChirpLength {
-		return "", errors.New("Chirp is too long")
+		return "", nil, errors.New("Chirp is too long")
 	}
-	// words to filter out
-	badWords := map[string]struct{}{
-		"kerfuffle": {},
-		"sharbert":  {},
-		"fornax":    {},
+	result := cfg.moderator.Moderate(body)
+	if result.Rejected {
+		return "", nil, ErrChirpRejected
 	}
-	// clean and return result body string
-	cleaned := getCleanedBody(body, badWords)
-	return cleaned, nil
-}
-
-func getCleanedBody(body string, badWords map[string]struct{}) string {
-	// convert body string to a slice of strings
-	words := strings.Split(body, " ")
-	for i, word := range words {
-		// lower case to compare
-		loweredWord := strings.ToLower(word)
-		if _, ok := badWords[loweredWord]; ok {
-			// replcae if matched
-			words[i] = "****"
+	// each flagging rule is listed once, however often it matched
+	flags := []string{}
+	seen := map[string]bool{}
+	for _, match := range result.Matches {
+		if match.Action != moderation.ActionFlag || seen[match.Rule] {
+			continue
 		}
+		seen[match.Rule] = true
+		flags = append(flags, match.Rule)
 	}
-	// convert slice of strings back to single string and return
-	cleaned := strings.Join(words, " ")
-	return cleaned
+	return result.Text, flags, nil
 }
@@ -2,50 +2,31 @@ package main
 
 import (
 	"net/http"
-	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/yuheng-liu/chirpy/internal/auth"
 )
 
 func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request) {
-	// retrieve the argument parameter, in this case the chirpID
-	chirpID, err := strconv.Atoi(chi.URLParam(r, "chirpID"))
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
-		return
-	}
-	// retrieve the jwt from request header
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
+	// user authenticated by the auth middleware
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
 		return
 	}
-	// check if retrieved jwt is valid, get back user ID as subject value
-	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
-		return
-	}
-	// convert user ID to int
-	userID, err := strconv.Atoi(subject)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't parse user ID")
-		return
-	}
-	// get chirp based on the chirpID
-	dbChirp, err := cfg.DB.GetChirp(chirpID)
+	// get chirp based on the chirpID argument parameter
+	dbChirp, err := cfg.getChirpByParam(chi.URLParam(r, "chirpID"))
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
 		return
 	}
 	// check if user id of request matches user id of chirp
-	if dbChirp.AuthorID != userID {
+	if dbChirp.AuthorID != user.ID {
 		respondWithError(w, http.StatusForbidden, "You can't delete this chirp")
 		return
 	}
 	// try to delete chirp from db and handle error if exists
-	err = cfg.DB.DeleteChirp(chirpID)
+	err = cfg.DB.DeleteChirp(dbChirp.ID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp")
 		return
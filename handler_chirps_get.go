@@ -1,44 +1,45 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
-	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/yuheng-liu/chirpy/internal/auth"
+	"github.com/yuheng-liu/chirpy/internal/database"
 )
 
 func (cfg *apiConfig) handlerChirpsGet(w http.ResponseWriter, r *http.Request) {
-	// retrieve the argument parameter, in this case the chirpID
-	chirpID, err := strconv.Atoi(chi.URLParam(r, "chirpID"))
+	// get chirp based on the chirpID argument parameter
+	dbChirp, err := cfg.getChirpByParam(chi.URLParam(r, "chirpID"))
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
 		return
 	}
-	// get chirp based on the chirpID
-	dbChirp, err := cfg.DB.GetChirp(chirpID)
-	if err != nil {
+	// chirps that aren't approved only exist for their author
+	viewerID := viewerID(r)
+	if dbChirp.Status != database.ChirpStatusApproved && dbChirp.AuthorID != viewerID {
 		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
 		return
 	}
+	// the author also gets to see why their chirp is held back
+	if dbChirp.AuthorID == viewerID {
+		respondWithJSON(w, http.StatusOK, chirpWithReviewFromDB(dbChirp))
+		return
+	}
 	// all checks passed, send response with proper data
-	respondWithJSON(w, http.StatusOK, Chirp{
-		ID:       dbChirp.ID,
-		AuthorID: dbChirp.AuthorID,
-		Body:     dbChirp.Body,
-	})
+	respondWithJSON(w, http.StatusOK, chirpFromDB(dbChirp))
 }
 
 func (cfg *apiConfig) handlerChirpsRetrieve(w http.ResponseWriter, r *http.Request) {
-	// get all chirps from db
-	dbChirps, err := cfg.DB.GetChirps()
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
-		return
-	}
-	// retrieve author id from request query parameters
-	authorID := -1
+	// retrieve author id from request query parameters, 0 means all authors
+	authorID := 0
 	authorIDString := r.URL.Query().Get("author_id")
+	var err error
 	if authorIDString != "" {
 		// convert user ID to int
 		authorID, err = strconv.Atoi(authorIDString)
@@ -48,32 +49,146 @@ func (cfg *apiConfig) handlerChirpsRetrieve(w http.ResponseWriter, r *http.Reque
 		}
 	}
 	// retrieve sort value from request query parameters, default to "asc"
-	sortDirection := "asc"
-	sortDirectionParam := r.URL.Query().Get("sort")
-	if sortDirectionParam == "desc" {
-		sortDirection = "desc"
+	chirpSort, err := parseChirpSort(r.URL.Query().Get("sort"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// retrieve creation time range from request query parameters
+	since, err := parseTimeParam(r, "since")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	until, err := parseTimeParam(r, "until")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// only paged when the client asks for it, clients from before pagination
+	// expect every chirp
+	paged := r.URL.Query().Has("limit") || r.URL.Query().Has("cursor")
+	// retrieve page size and position from request query parameters
+	limit, err := parsePageLimit(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// one more than the page to know if there is a next page
+	queryLimit := limit + 1
+	if !paged {
+		queryLimit = 0
+	}
+	cursor, err := parsePageCursor(r, chirpSort.name)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// get the chirps from db, only approved chirps are public, authors also see their own pending ones
+	dbChirps, err := cfg.DB.ListChirps(database.ChirpQuery{
+		AuthorID:       authorID,
+		Statuses:       publicChirpStatuses,
+		ViewerID:       viewerID(r),
+		Since:          since,
+		Until:          until,
+		SortBy:         chirpSort.field,
+		Desc:           chirpSort.desc,
+		AfterID:        cursor.ID,
+		AfterCreatedAt: cursor.CreatedAt,
+		Limit:          queryLimit,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
+		return
+	}
+	if paged && len(dbChirps) > limit {
+		dbChirps = dbChirps[:limit]
+		last := dbChirps[len(dbChirps)-1]
+		setNextPageLink(w, r, pageCursor{
+			Sort:      chirpSort.name,
+			ID:        last.ID,
+			CreatedAt: last.CreatedAt,
+		})
 	}
-	// convert chirps from db struct to response struct
+	// convert chirps from db struct to response struct, already sorted by the db
 	chirps := []Chirp{}
 	for _, dbChirp := range dbChirps {
-		// skip adding of chirps if doesn't match author ID
-		if authorID != -1 && dbChirp.AuthorID != authorID {
-			continue
-		}
+		chirps = append(chirps, chirpFromDB(dbChirp))
+	}
+	// send response with the page of chirps
+	respondWithJSON(w, http.StatusOK, chirps)
+}
 
-		chirps = append(chirps, Chirp{
-			ID:       dbChirp.ID,
-			AuthorID: dbChirp.AuthorID,
-			Body:     dbChirp.Body,
-		})
+// statuses of chirps anyone can see
+var publicChirpStatuses = []database.ChirpStatus{database.ChirpStatusApproved}
+
+// id of the user making the request on routes with optional auth, 0 for
+// anonymous requests, so they can show more to the owner
+func viewerID(r *http.Request) int {
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		return 0
+	}
+	return user.ID
+}
+
+// look up a chirp by its integer id, or by its opaque public id otherwise
+func (cfg *apiConfig) getChirpByParam(chirpIDParam string) (database.Chirp, error) {
+	chirpID, err := strconv.Atoi(chirpIDParam)
+	if err != nil {
+		return cfg.DB.GetChirpByPublicID(chirpIDParam)
+	}
+	return cfg.DB.GetChirp(chirpID)
+}
+
+// chirp ordering requested through the sort query parameter
+type chirpSort struct {
+	field database.ChirpSort
+	desc  bool
+	// normalized value, stored in cursors so they only continue the same order
+	name string
+}
+
+// parse "asc", "desc" or "<field>[:asc|:desc]" where field is id or created_at
+func parseChirpSort(param string) (chirpSort, error) {
+	switch param {
+	case "", "asc":
+		return chirpSort{field: database.ChirpSortID, name: "asc"}, nil
+	case "desc":
+		return chirpSort{field: database.ChirpSortID, desc: true, name: "desc"}, nil
 	}
-	// sort slice of Chirps based on chirp ID, sort direction is based on sortDirection value
-	sort.Slice(chirps, func(i, j int) bool {
-		if sortDirection == "desc" {
-			return chirps[i].ID > chirps[j].ID
+	field, direction, _ := strings.Cut(param, ":")
+	if direction != "" && direction != "asc" && direction != "desc" {
+		return chirpSort{}, errors.New("Invalid sort direction")
+	}
+	desc := direction == "desc"
+	switch database.ChirpSort(field) {
+	case database.ChirpSortID:
+		// same as plain "asc" and "desc"
+		if desc {
+			return parseChirpSort("desc")
 		}
-		return chirps[i].ID < chirps[j].ID
-	})
-	// send response with final sorted slice of chirps
-	respondWithJSON(w, http.StatusOK, chirps)
+		return parseChirpSort("asc")
+	case database.ChirpSortCreatedAt:
+		name := string(database.ChirpSortCreatedAt) + ":asc"
+		if desc {
+			name = string(database.ChirpSortCreatedAt) + ":desc"
+		}
+		return chirpSort{field: database.ChirpSortCreatedAt, desc: desc, name: name}, nil
+	default:
+		return chirpSort{}, errors.New("Invalid sort field")
+	}
+}
+
+// read an RFC 3339 time query parameter, zero time if absent
+func parseTimeParam(r *http.Request, name string) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid %s time, expected RFC 3339", name)
+	}
+	return t, nil
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/yuheng-liu/chirpy/internal/database"
+)
+
+func TestChirpsRetrievePaging(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cfg := &apiConfig{DB: db}
+	// more than a default page
+	total := defaultPageLimit + 20
+	for i := 0; i < total; i++ {
+		_, err := db.CreateChirp(fmt.Sprintf("chirp %d", i), 1, database.ChirpStatusApproved, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query    string
+		want     int
+		nextPage bool
+	}{
+		// without paging parameters every chirp comes back, as before paging existed
+		{"", total, false},
+		{"?sort=desc", total, false},
+		{"?limit=50", 50, true},
+		{fmt.Sprintf("?limit=%d", total), total, false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		cfg.handlerChirpsRetrieve(w, httptest.NewRequest(http.MethodGet, "/api/chirps"+tt.query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %q: status %d: %s", tt.query, w.Code, w.Body)
+		}
+		chirps := []Chirp{}
+		if err := json.Unmarshal(w.Body.Bytes(), &chirps); err != nil {
+			t.Fatal(err)
+		}
+		if len(chirps) != tt.want {
+			t.Errorf("GET %q: %d chirps, want %d", tt.query, len(chirps), tt.want)
+		}
+		if hasNext := w.Header().Get("Link") != ""; hasNext != tt.nextPage {
+			t.Errorf("GET %q: next page link %v, want %v", tt.query, hasNext, tt.nextPage)
+		}
+	}
+}
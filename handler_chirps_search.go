@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/yuheng-liu/chirpy/internal/database"
+)
+
+// sort name stored in search cursors, so list cursors can't be reused here
+const searchSortRelevance = "relevance"
+
+func (cfg *apiConfig) handlerChirpsSearch(w http.ResponseWriter, r *http.Request) {
+	// retrieve search text from request query parameters
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing search query")
+		return
+	}
+	// retrieve author id from request query parameters, 0 means all authors
+	authorID := 0
+	authorIDString := r.URL.Query().Get("author_id")
+	var err error
+	if authorIDString != "" {
+		// convert user ID to int
+		authorID, err = strconv.Atoi(authorIDString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid author ID")
+			return
+		}
+	}
+	// retrieve page size and position from request query parameters
+	limit, err := parsePageLimit(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cursor, err := parsePageCursor(r, searchSortRelevance)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// search chirps in db, plus one more to know if there is a next page
+	// only approved chirps are public, authors also see their own pending ones
+	matches, err := cfg.DB.SearchChirps(database.SearchQuery{
+		Text:       q,
+		AuthorID:   authorID,
+		Statuses:   publicChirpStatuses,
+		ViewerID:   viewerID(r),
+		AfterScore: cursor.Score,
+		AfterID:    cursor.ID,
+		Limit:      limit + 1,
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrEmptySearch) {
+			respondWithError(w, http.StatusBadRequest, "Search query has no words")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search chirps")
+		return
+	}
+	if len(matches) > limit {
+		matches = matches[:limit]
+		last := matches[len(matches)-1]
+		setNextPageLink(w, r, pageCursor{
+			Sort:  searchSortRelevance,
+			ID:    last.Chirp.ID,
+			Score: last.Score,
+		})
+	}
+	// convert chirps from db struct to response struct, best match first
+	chirps := []Chirp{}
+	for _, match := range matches {
+		chirps = append(chirps, chirpFromDB(match.Chirp))
+	}
+	respondWithJSON(w, http.StatusOK, chirps)
+}
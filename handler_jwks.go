@@ -0,0 +1,10 @@
+package main
+
+import "net/http"
+
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	// verifiers may cache the keys for a while, new keys should be added well
+	// before they start signing
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, cfg.jwtKeys.JWKS())
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yuheng-liu/chirpy/internal/auth"
+	"github.com/yuheng-liu/chirpy/internal/database"
+)
+
+// longest name a user can give an api key
+const maxAPIKeyNameLength = 100
+
+type APIKey struct {
+	ID     int              `json:"id"`
+	Name   string           `json:"name"`
+	Prefix string           `json:"prefix"`
+	Scopes []database.Scope `json:"scopes"`
+	// null until the key is used
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// convert api key from db struct to response struct, leaving out the hash
+func apiKeyFromDB(dbKey database.APIKey) APIKey {
+	key := APIKey{
+		ID:        dbKey.ID,
+		Name:      dbKey.Name,
+		Prefix:    dbKey.Prefix,
+		Scopes:    dbKey.Scopes,
+		CreatedAt: dbKey.CreatedAt,
+	}
+	if !dbKey.LastUsedAt.IsZero() {
+		key.LastUsedAt = &dbKey.LastUsedAt
+	}
+	return key
+}
+
+func (cfg *apiConfig) handlerKeysCreate(w http.ResponseWriter, r *http.Request) {
+	// for converting request json to local struct
+	type parameters struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	// for response struct to reply to request
+	type response struct {
+		APIKey
+		// the whole key, shown only this once
+		Key string `json:"key"`
+	}
+	// user authenticated by the auth middleware
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	// decoding json to struct and handle error
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+	if params.Name == "" || len(params.Name) > maxAPIKeyNameLength {
+		respondWithError(w, http.StatusBadRequest, "Name must be 1 to 100 characters")
+		return
+	}
+	if len(params.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one scope is required")
+		return
+	}
+	// validate every scope, repeated scopes are only stored once
+	scopes := []database.Scope{}
+	seen := map[database.Scope]bool{}
+	for _, s := range params.Scopes {
+		scope, err := database.ParseScope(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid scope "+strconv.Quote(s))
+			return
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	key, prefix, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key")
+		return
+	}
+	// only the hash of the key is stored
+	dbKey, err := cfg.DB.CreateAPIKey(database.APIKey{
+		UserID: user.ID,
+		Name:   params.Name,
+		Prefix: prefix,
+		Hash:   auth.HashAPIKey(key),
+		Scopes: scopes,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key")
+		return
+	}
+	// all checks passed, send response with proper data
+	respondWithJSON(w, http.StatusCreated, response{
+		APIKey: apiKeyFromDB(dbKey),
+		Key:    key,
+	})
+}
+
+func (cfg *apiConfig) handlerKeysList(w http.ResponseWriter, r *http.Request) {
+	// user authenticated by the auth middleware
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	dbKeys, err := cfg.DB.ListAPIKeys(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve API keys")
+		return
+	}
+	// convert api keys from db struct to response struct, newest first
+	keys := []APIKey{}
+	for _, dbKey := range dbKeys {
+		keys = append(keys, apiKeyFromDB(dbKey))
+	}
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+func (cfg *apiConfig) handlerKeysRevoke(w http.ResponseWriter, r *http.Request) {
+	// user authenticated by the auth middleware
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	// convert key ID from the url to int
+	keyID, err := strconv.Atoi(chi.URLParam(r, "keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+	// other users' keys look the same as missing ones
+	err = cfg.DB.DeleteAPIKey(user.ID, keyID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find API key")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API key")
+		return
+	}
+	// all checks passed, send response without any body
+	respondWithJSON(w, http.StatusOK, struct{}{})
+}
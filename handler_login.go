@@ -2,10 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/yuheng-liu/chirpy/internal/auth"
+	"github.com/yuheng-liu/chirpy/internal/database"
 )
 
 func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
@@ -15,11 +18,10 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		Email            string `json:"email"`
 		ExpiresInSeconds int    `json:"expires_in_seconds"`
 	}
-	// for response struct to reply to request
-	type response struct {
-		User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
+	// for response struct when a second factor is needed to finish the login
+	type challengeResponse struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
 	}
 	// decoding json to struct and handle error
 	decoder := json.NewDecoder(r.Body)
@@ -29,48 +31,110 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
 		return
 	}
+	// refuse to check passwords for accounts or addresses that failed too often
+	ip := clientIP(r)
+	wait, err := cfg.lockout.Check(params.Email, ip)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts")
+		return
+	}
+	if wait > 0 {
+		respondLockedOut(w, wait)
+		return
+	}
 	// get user based on email and handle error
 	user, err := cfg.DB.GetUserByEmail(params.Email)
 	if err != nil {
+		// guessing emails counts against the address as well
+		if errors.Is(err, database.ErrNotExist) {
+			cfg.recordLoginFailure(params.Email, ip)
+		}
 		respondWithError(w, http.StatusInternalServerError, "Couldn't get user")
 		return
 	}
 	// check user against request's password to authenticate user
-	err = auth.CheckPasswordHash(params.Password, user.HashedPassword)
-	if err != nil {
+	rehash, err := cfg.passwords.Check(params.Password, user.HashedPassword)
+	if errors.Is(err, auth.ErrPasswordMismatch) {
+		cfg.recordLoginFailure(params.Email, ip)
 		respondWithError(w, http.StatusUnauthorized, "Invalid password")
 		return
 	}
-	// creates a new jwt that represents the access token
-	accessToken, err := auth.MakeJWT(
-		user.ID,
-		cfg.jwtSecret,
-		time.Hour,
-		auth.TokenTypeAccess,
-	)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check password")
+		return
+	}
+	// the plaintext is only around now, so older hashes are upgraded here
+	if rehash {
+		cfg.rehashPassword(user, params.Password)
+	}
+	// with two-factor authentication on the password only earns a challenge,
+	// answered with a code at /api/login/2fa
+	if user.TOTPEnabled {
+		challenge, err := auth.MakeMFAChallengeToken(user.ID, cfg.jwtKeys, mfaChallengeLifetime)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create MFA token")
+			return
+		}
+		respondWithJSON(w, http.StatusOK, challengeResponse{
+			MFARequired: true,
+			MFAToken:    challenge,
+		})
+		return
+	}
+	cfg.respondWithLogin(w, r, user, params.ExpiresInSeconds)
+}
+
+// loginResponse - the user with the tokens of their new session
+type loginResponse struct {
+	User
+	Token        string    `json:"token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	RefreshToken string    `json:"refresh_token"`
+	// when the session ends and the refresh token stops working
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+}
+
+// finish a login that passed every check, starting a session for the user
+// with an access token valid for as long as the client asked up to the maximum
+func (cfg *apiConfig) respondWithLogin(w http.ResponseWriter, r *http.Request, user database.User, expiresInSeconds int) {
+	// the streak of failures for the account ends with a successful login
+	err := cfg.lockout.Success(user.Email)
+	if err != nil {
+		log.Printf("Couldn't clear login attempts: %s", err)
+	}
+	// start a new session, its opaque refresh token is only stored hashed
+	refreshToken, session, err := cfg.startSession(r, user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create session")
 		return
 	}
-	// creates a new jwt that represents the refresh token
-	refreshToken, err := auth.MakeJWT(
+	// creates a new jwt that represents the access token, carrying the user's roles
+	accessToken, expiresAt, err := auth.MakeJWT(
 		user.ID,
-		cfg.jwtSecret,
-		time.Hour*24*30*6,
-		auth.TokenTypeRefresh,
+		session.ID,
+		roleNames(user.Roles),
+		cfg.jwtKeys,
+		cfg.lifetimes.accessFor(expiresInSeconds),
+		auth.TokenTypeAccess,
 	)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh JWT")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT")
 		return
 	}
 	// all checks passed, send response with proper data
-	respondWithJSON(w, http.StatusOK, response{
-		User: User{
-			ID:          user.ID,
-			Email:       user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		},
-		Token:        accessToken,
-		RefreshToken: refreshToken,
+	respondWithJSON(w, http.StatusOK, loginResponse{
+		User:                  userFromDB(user),
+		Token:                 accessToken,
+		ExpiresAt:             expiresAt,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: session.ExpiresAt,
 	})
 }
+
+// count a failed login, a failure to count it doesn't change the response
+func (cfg *apiConfig) recordLoginFailure(email, ip string) {
+	err := cfg.lockout.Failure(email, ip)
+	if err != nil {
+		log.Printf("Couldn't record failed login: %s", err)
+	}
+}
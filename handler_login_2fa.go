@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/yuheng-liu/chirpy/internal/auth"
+)
+
+// second step of logging in for users with two-factor authentication, trades
+// the challenge from /api/login and a code for the usual tokens
+func (cfg *apiConfig) handlerLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	// for converting request json to local struct
+	type parameters struct {
+		MFAToken         string `json:"mfa_token"`
+		Code             string `json:"code"`
+		RecoveryCode     string `json:"recovery_code"`
+		ExpiresInSeconds int    `json:"expires_in_seconds"`
+	}
+	// decoding json to struct and handle error
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+	if params.Code == "" && params.RecoveryCode == "" {
+		respondWithError(w, http.StatusBadRequest, "Code is required")
+		return
+	}
+	// the challenge proves the password was right a moment ago
+	challenge, err := auth.ParseMFAChallengeToken(params.MFAToken, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid MFA token")
+		return
+	}
+	user, err := cfg.DB.GetUser(challenge.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid MFA token")
+		return
+	}
+	// turned off since the password was checked, log in again
+	if !user.TOTPEnabled {
+		respondWithError(w, http.StatusUnauthorized, "Invalid MFA token")
+		return
+	}
+	// issued before the password changed or the user logged out everywhere
+	if challenge.IssuedAt.Before(user.TokensValidAfter.Truncate(time.Microsecond)) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid MFA token")
+		return
+	}
+	// guessing codes counts against the account like guessing passwords
+	ip := clientIP(r)
+	wait, err := cfg.lockout.Check(user.Email, ip)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts")
+		return
+	}
+	if wait > 0 {
+		respondLockedOut(w, wait)
+		return
+	}
+	err = cfg.useSecondFactor(user, params.Code, params.RecoveryCode)
+	if errors.Is(err, errInvalidCode) {
+		cfg.recordLoginFailure(user.Email, ip)
+		respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code")
+		return
+	}
+	// a challenge only logs in once, a copy of it can't start another session
+	if !cfg.denylist.UseMFAChallenge(challenge.TokenID, challenge.ExpiresAt) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid MFA token")
+		return
+	}
+	cfg.respondWithLogin(w, r, user, params.ExpiresInSeconds)
+}
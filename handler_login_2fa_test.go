@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yuheng-liu/chirpy/internal/auth"
+	"github.com/yuheng-liu/chirpy/internal/database"
+)
+
+// user with two-factor authentication on, logging in with recovery codes
+func newTestTwoFactorUser(t *testing.T, cfg *apiConfig) (database.User, []string) {
+	t.Helper()
+	hashedPassword, err := cfg.passwords.Hash("the old passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := cfg.DB.CreateUser("a@example.com", hashedPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := auth.MakeRecoveryCodes(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+	if _, err := cfg.DB.StartTOTPEnrollment(user.ID, secret); err != nil {
+		t.Fatal(err)
+	}
+	user, err = cfg.DB.EnableTOTP(user.ID, secret, 0, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, codes
+}
+
+func loginChallenge(t *testing.T, cfg *apiConfig) string {
+	t.Helper()
+	w := post(cfg.handlerLogin, `{"email":"a@example.com","password":"the old passphrase"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: status %d, want 200: %s", w.Code, w.Body)
+	}
+	resp := struct {
+		MFAToken string `json:"mfa_token"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.MFAToken
+}
+
+func TestLoginTwoFactorChallengeWorksOnce(t *testing.T) {
+	cfg := newTestResetConfig(t, t.TempDir())
+	_, codes := newTestTwoFactorUser(t, cfg)
+	challenge := loginChallenge(t, cfg)
+
+	w := post(cfg.handlerLoginTwoFactor, `{"mfa_token":"`+challenge+`","recovery_code":"`+codes[0]+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("first answer: status %d, want 200: %s", w.Code, w.Body)
+	}
+	// another valid code doesn't make the challenge good again
+	w = post(cfg.handlerLoginTwoFactor, `{"mfa_token":"`+challenge+`","recovery_code":"`+codes[1]+`"}`)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("replayed challenge: status %d, want 401: %s", w.Code, w.Body)
+	}
+}
+
+func TestLoginTwoFactorChallengeRevokedWithTokens(t *testing.T) {
+	cfg := newTestResetConfig(t, t.TempDir())
+	user, codes := newTestTwoFactorUser(t, cfg)
+	challenge := loginChallenge(t, cfg)
+
+	if _, err := cfg.DB.InvalidateUserTokens(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	w := post(cfg.handlerLoginTwoFactor, `{"mfa_token":"`+challenge+`","recovery_code":"`+codes[0]+`"}`)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("challenge from before invalidation: status %d, want 401: %s", w.Code, w.Body)
+	}
+}
+
+func TestTwoFactorDisableLocksOut(t *testing.T) {
+	cfg := newTestResetConfig(t, t.TempDir())
+	user, _ := newTestTwoFactorUser(t, cfg)
+
+	disable := func(password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"password":"`+password+`"}`))
+		r = r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{User: user}))
+		cfg.handlerTwoFactorDisable(w, r)
+		return w
+	}
+	for i := 0; i < auth.DefaultAccountPolicy.Threshold; i++ {
+		if w := disable("a wrong guess"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("guess %d: status %d, want 401: %s", i, w.Code, w.Body)
+		}
+	}
+	// locked out, even the right password isn't checked
+	if w := disable("the old passphrase"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("after guessing: status %d, want 429: %s", w.Code, w.Body)
+	}
+	user, err := cfg.DB.GetUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.TOTPEnabled {
+		t.Error("two-factor authentication was turned off while locked out")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yuheng-liu/chirpy/internal/database"
+)
+
+// sort name stored in moderation queue cursors, oldest chirp first
+const moderationSortQueue = "queue"
+
+func (cfg *apiConfig) handlerModerationQueue(w http.ResponseWriter, r *http.Request) {
+	// retrieve status from request query parameters, default to the pending queue
+	status := database.ChirpStatusPending
+	if statusString := r.URL.Query().Get("status"); statusString != "" {
+		var err error
+		status, err = database.ParseChirpStatus(statusString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid status")
+			return
+		}
+	}
+	// retrieve page size and position from request query parameters
+	limit, err := parsePageLimit(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cursor, err := parsePageCursor(r, moderationSortQueue+":"+string(status))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// get one page of chirps from db, plus one more to know if there is a next page
+	dbChirps, err := cfg.DB.ListChirps(database.ChirpQuery{
+		Statuses: []database.ChirpStatus{status},
+		SortBy:   database.ChirpSortID,
+		AfterID:  cursor.ID,
+		Limit:    limit + 1,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
+		return
+	}
+	if len(dbChirps) > limit {
+		dbChirps = dbChirps[:limit]
+		setNextPageLink(w, r, pageCursor{
+			Sort: moderationSortQueue + ":" + string(status),
+			ID:   dbChirps[len(dbChirps)-1].ID,
+		})
+	}
+	// moderators see why each chirp was flagged
+	chirps := []Chirp{}
+	for _, dbChirp := range dbChirps {
+		chirps = append(chirps, chirpWithReviewFromDB(dbChirp))
+	}
+	respondWithJSON(w, http.StatusOK, chirps)
+}
+
+func (cfg *apiConfig) handlerModerationApprove(w http.ResponseWriter, r *http.Request) {
+	cfg.reviewChirp(w, r, database.ChirpStatusApproved)
+}
+
+func (cfg *apiConfig) handlerModerationReject(w http.ResponseWriter, r *http.Request) {
+	cfg.reviewChirp(w, r, database.ChirpStatusRejected)
+}
+
+// record a moderator's decision on the chirp in the url, a reason is optional
+// when approving but required when rejecting so the author can be told why
+func (cfg *apiConfig) reviewChirp(w http.ResponseWriter, r *http.Request, status database.ChirpStatus) {
+	// for converting request json to local struct
+	type parameters struct {
+		Reason string `json:"reason"`
+	}
+	// decoding json to struct and handle error, an empty body means no reason
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	if status == database.ChirpStatusRejected && params.Reason == "" {
+		respondWithError(w, http.StatusBadRequest, "Rejecting a chirp needs a reason")
+		return
+	}
+	// get chirp based on the chirpID argument parameter
+	dbChirp, err := cfg.getChirpByParam(chi.URLParam(r, "chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+		return
+	}
+	// save the decision to db, handle error
+	dbChirp, err = cfg.DB.SetChirpStatus(dbChirp.ID, status, params.Reason)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldn't get chirp")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp")
+		return
+	}
+	// all checks passed, send response with proper data
+	respondWithJSON(w, http.StatusOK, chirpWithReviewFromDB(dbChirp))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/yuheng-liu/chirpy/internal/auth"
+	"github.com/yuheng-liu/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handlerPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	// for converting request json to local struct
+	type parameters struct {
+		Email string `json:"email"`
+	}
+	// decoding json to struct and handle error
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	// the response is the same whether or not the email has an account, so
+	// it can't be used to find out who uses chirpy
+	user, err := cfg.DB.GetUserByEmail(params.Email)
+	if err != nil {
+		if !errors.Is(err, database.ErrNotExist) {
+			log.Printf("Couldn't get user for password reset: %s", err)
+		}
+		respondWithJSON(w, http.StatusAccepted, struct{}{})
+		return
+	}
+	token, err := auth.MakePasswordResetToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create reset token")
+		return
+	}
+	// only the hash is stored, a leaked db can't be used to reset passwords
+	_, err = cfg.DB.CreatePasswordReset(user.ID, auth.HashPasswordResetToken(token), time.Now().UTC().Add(passwordResetLifetime))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create reset token")
+		return
+	}
+	// sent in the background, so a slow mail server doesn't give away that
+	// the account exists
+	go func() {
+		err := cfg.sendPasswordResetEmail(user, token)
+		if err != nil {
+			log.Printf("Couldn't send password reset email to user %d: %s", user.ID, err)
+		}
+	}()
+	respondWithJSON(w, http.StatusAccepted, struct{}{})
+}
+
+func (cfg *apiConfig) handlerPasswordReset(w http.ResponseWriter, r *http.Request) {
+	// for converting request json to local struct
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	// decoding json to struct and handle error
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	if !cfg.checkPassword(w, params.Password) {
+		return
+	}
+	// hash password and handle error
+	hashedPassword, err := cfg.passwords.Hash(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
+		return
+	}
+	// use up the token and set the new password together
+	user, err := cfg.DB.ResetPassword(auth.HashPasswordResetToken(params.Token), hashedPassword)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) || errors.Is(err, database.ErrResetTokenInvalid) {
+			respondWithError(w, http.StatusBadRequest, "Reset token is invalid")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't reset password")
+		return
+	}
+	// whoever knew the old password is logged out everywhere
+	_, err = cfg.DB.RevokeUserSessions(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions")
+		return
+	}
+	user, err = cfg.DB.InvalidateUserTokens(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke access tokens")
+		return
+	}
+	// api keys could have been made by whoever got into the account
+	_, err = cfg.DB.RevokeUserAPIKeys(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke api keys")
+		return
+	}
+	// a lockout from someone guessing the old password no longer matters
+	err = cfg.lockout.Unlock(user.Email)
+	if err != nil {
+		log.Printf("Couldn't clear login attempts: %s", err)
+	}
+	// all checks passed, send response with proper data
+	respondWithJSON(w, http.StatusOK, userFromDB(user))
+}
@@ -1,41 +1,80 @@
 package main
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/yuheng-liu/chirpy/internal/auth"
+	"github.com/yuheng-liu/chirpy/internal/database"
 )
 
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string    `json:"token"`
+		ExpiresAt    time.Time `json:"expires_at"`
+		RefreshToken string    `json:"refresh_token"`
+		// refreshing doesn't extend the session, this stays the same
+		RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
 	}
 	// retrieve the refresh token from request header
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't find JWT")
+		respondWithError(w, http.StatusBadRequest, "Couldn't find refresh token")
 		return
 	}
-	// check if refresh token is in revoked map in db
-	isRevoked, err := cfg.DB.IsTokenRevoked(refreshToken)
+	// every refresh hands out a new refresh token and retires the old one
+	newRefreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't check session")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token")
 		return
 	}
-	// if refresh token is revoked, return error
-	if isRevoked {
-		respondWithError(w, http.StatusUnauthorized, "Refresh token is revoked")
+	session, err := cfg.DB.RotateRefreshToken(
+		auth.HashRefreshToken(refreshToken),
+		auth.HashRefreshToken(newRefreshToken),
+		time.Now().UTC().Add(-refreshReuseWindow),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrTokenReused):
+			// someone else holds a copy of the token, the whole session is revoked
+			// including the access tokens handed out for it
+			cfg.denylistSession(session.ID)
+			log.Printf("Refresh token reused, revoked session %d of user %d", session.ID, session.UserID)
+			respondWithError(w, http.StatusUnauthorized, "Refresh token was already used")
+		case errors.Is(err, database.ErrNotExist), errors.Is(err, database.ErrSessionRevoked), errors.Is(err, database.ErrSessionExpired):
+			respondWithError(w, http.StatusUnauthorized, "Refresh token is invalid")
+		default:
+			respondWithError(w, http.StatusInternalServerError, "Couldn't refresh session")
+		}
+		return
+	}
+	// roles are read again so changes apply from the next refresh
+	user, err := cfg.DB.GetUser(session.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user")
 		return
 	}
-	// refresh the token and generate a new accessToken
-	accessToken, err := auth.RefreshToken(refreshToken, cfg.jwtSecret)
+	// generate a new accessToken for the same user
+	accessToken, expiresAt, err := auth.MakeJWT(
+		user.ID,
+		session.ID,
+		roleNames(user.Roles),
+		cfg.jwtKeys,
+		cfg.lifetimes.access,
+		auth.TokenTypeAccess,
+	)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT")
 		return
 	}
-	// all checks passed, send response with new access token
+	// all checks passed, send response with the new tokens
 	respondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:                 accessToken,
+		ExpiresAt:             expiresAt,
+		RefreshToken:          newRefreshToken,
+		RefreshTokenExpiresAt: session.ExpiresAt,
 	})
 }
 
@@ -43,15 +82,22 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 	// retrieve the refresh token from request header
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't find JWT")
+		respondWithError(w, http.StatusBadRequest, "Couldn't find refresh token")
+		return
+	}
+	// log out the session the refresh token belongs to, unknown tokens are
+	// already as good as revoked
+	sessionID, err := cfg.DB.RevokeSessionByToken(auth.HashRefreshToken(refreshToken))
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithJSON(w, http.StatusOK, struct{}{})
 		return
 	}
-	// revoke the refresh token by adding the refresh token to revoked tokens map in db
-	err = cfg.DB.RevokeToken(refreshToken)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session")
 		return
 	}
+	// access tokens of the session stay valid until they expire unless denylisted
+	cfg.denylistSession(sessionID)
 	// all checks passed, send response without any body
 	respondWithJSON(w, http.StatusOK, struct{}{})
 }
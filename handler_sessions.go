@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yuheng-liu/chirpy/internal/auth"
+	"github.com/yuheng-liu/chirpy/internal/database"
+)
+
+type Session struct {
+	ID         int       `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// whether the request was made with a token of this session
+	Current bool `json:"current"`
+}
+
+func (cfg *apiConfig) handlerSessionsList(w http.ResponseWriter, r *http.Request) {
+	// user authenticated by the auth middleware
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	// get the user's sessions that can still be refreshed
+	dbSessions, err := cfg.DB.ListActiveSessions(principal.User.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve sessions")
+		return
+	}
+	// convert sessions from db struct to response struct, most recently used first
+	sessions := []Session{}
+	for _, dbSession := range dbSessions {
+		sessions = append(sessions, Session{
+			ID:         dbSession.ID,
+			UserAgent:  dbSession.UserAgent,
+			IP:         dbSession.IP,
+			CreatedAt:  dbSession.CreatedAt,
+			LastUsedAt: dbSession.LastUsedAt,
+			ExpiresAt:  dbSession.ExpiresAt,
+			Current:    dbSession.ID == principal.Claims.SessionID,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+func (cfg *apiConfig) handlerSessionsRevoke(w http.ResponseWriter, r *http.Request) {
+	// user authenticated by the auth middleware
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	// convert session ID from the url to int
+	sessionID, err := strconv.Atoi(chi.URLParam(r, "sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+	// log out the session, other users' sessions look the same as missing ones
+	err = cfg.DB.RevokeSession(user.ID, sessionID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find session")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session")
+		return
+	}
+	// access tokens of the session stay valid until they expire unless denylisted
+	cfg.denylistSession(sessionID)
+	// all checks passed, send response without any body
+	respondWithJSON(w, http.StatusOK, struct{}{})
+}
+
+// log out everywhere, including the session making the request, and revoke
+// every api key
+func (cfg *apiConfig) handlerSessionsRevokeAll(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Revoked int `json:"revoked"`
+	}
+	// user authenticated by the auth middleware
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	revoked, err := cfg.DB.RevokeUserSessions(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions")
+		return
+	}
+	// reject every access token issued so far, including the one of this request
+	_, err = cfg.DB.InvalidateUserTokens(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke access tokens")
+		return
+	}
+	// api keys could have been made by whoever got into the account
+	_, err = cfg.DB.RevokeUserAPIKeys(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke api keys")
+		return
+	}
+	// all checks passed, send response with the number of sessions logged out
+	respondWithJSON(w, http.StatusOK, response{
+		Revoked: revoked,
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/yuheng-liu/chirpy/internal/auth"
+	"github.com/yuheng-liu/chirpy/internal/database"
+)
+
+// first step of turning on two-factor authentication, gives the user a new
+// secret to add to their authenticator app
+func (cfg *apiConfig) handlerTwoFactorEnroll(w http.ResponseWriter, r *http.Request) {
+	// for response struct to reply to request
+	type response struct {
+		Secret string `json:"secret"`
+		// for showing as a QR code
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+	// user authenticated by the auth middleware
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create secret")
+		return
+	}
+	// not in use until confirmed, enrolling again replaces it
+	user, err = cfg.DB.StartTOTPEnrollment(user.ID, secret)
+	if errors.Is(err, database.ErrTwoFactorEnabled) {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start enrollment")
+		return
+	}
+	// all checks passed, send response with proper data
+	respondWithJSON(w, http.StatusOK, response{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// second step of turning on two-factor authentication, a code from the
+// authenticator app proves it was set up, answers with the recovery codes
+func (cfg *apiConfig) handlerTwoFactorConfirm(w http.ResponseWriter, r *http.Request) {
+	// for converting request json to local struct
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	// for response struct to reply to request
+	type response struct {
+		// shown only this once
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	// user authenticated by the auth middleware
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	// decoding json to struct and handle error
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+	if user.TOTPEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if user.TOTPSecret == "" {
+		respondWithError(w, http.StatusBadRequest, "Enroll before confirming")
+		return
+	}
+	step, err := auth.ValidateTOTP(user.TOTPSecret, params.Code, time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid code")
+		return
+	}
+	recoveryCodes, err := auth.MakeRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create recovery codes")
+		return
+	}
+	hashes := make([]string, 0, len(recoveryCodes))
+	for _, code := range recoveryCodes {
+		hashes = append(hashes, auth.HashRecoveryCode(code))
+	}
+	// the code's step counts as used, it can't log in a second time
+	_, err = cfg.DB.EnableTOTP(user.ID, user.TOTPSecret, step, hashes)
+	if errors.Is(err, database.ErrTwoFactorEnabled) {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if errors.Is(err, database.ErrNotExist) {
+		respondWithError(w, http.StatusConflict, "Enrollment was restarted, confirm the new secret")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication")
+		return
+	}
+	// all checks passed, send response with proper data
+	respondWithJSON(w, http.StatusOK, response{
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// turns two-factor authentication off, the password is asked for again so a
+// stolen access token isn't enough
+func (cfg *apiConfig) handlerTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	// for converting request json to local struct
+	type parameters struct {
+		Password string `json:"password"`
+	}
+	// user authenticated by the auth middleware
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	// decoding json to struct and handle error
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
+		return
+	}
+	// guessing the password here counts against the account like a login
+	ip := clientIP(r)
+	wait, err := cfg.lockout.Check(user.Email, ip)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login attempts")
+		return
+	}
+	if wait > 0 {
+		respondLockedOut(w, wait)
+		return
+	}
+	_, err = cfg.passwords.Check(params.Password, user.HashedPassword)
+	if errors.Is(err, auth.ErrPasswordMismatch) {
+		cfg.recordLoginFailure(user.Email, ip)
+		respondWithError(w, http.StatusUnauthorized, "Invalid password")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check password")
+		return
+	}
+	_, err = cfg.DB.DisableTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't disable two-factor authentication")
+		return
+	}
+	// all checks passed, send response without any body
+	respondWithJSON(w, http.StatusOK, struct{}{})
+}
@@ -3,17 +3,39 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"log"
 	"net/http"
+	"time"
 
-	"github.com/yuheng-liu/chirpy/internal/auth"
 	"github.com/yuheng-liu/chirpy/internal/database"
 )
 
 type User struct {
-	ID          int    `json:"id"`
-	Email       string `json:"email"`
-	Password    string `json:"-"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	ID               int             `json:"id"`
+	PublicID         string          `json:"public_id,omitempty"`
+	Email            string          `json:"email"`
+	EmailVerified    bool            `json:"email_verified"`
+	TwoFactorEnabled bool            `json:"two_factor_enabled"`
+	Password         string          `json:"-"`
+	IsChirpyRed      bool            `json:"is_chirpy_red"`
+	Roles            []database.Role `json:"roles"`
+	CreatedAt        time.Time       `json:"created_at"`
+	UpdatedAt        time.Time       `json:"updated_at"`
+}
+
+// convert user from db struct to response struct, leaving out the password
+func userFromDB(dbUser database.User) User {
+	return User{
+		ID:               dbUser.ID,
+		PublicID:         dbUser.PublicID,
+		Email:            dbUser.Email,
+		EmailVerified:    dbUser.EmailVerified,
+		TwoFactorEnabled: dbUser.TOTPEnabled,
+		IsChirpyRed:      dbUser.IsChirpyRed,
+		Roles:            dbUser.Roles,
+		CreatedAt:        dbUser.CreatedAt,
+		UpdatedAt:        dbUser.UpdatedAt,
+	}
 }
 
 func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request) {
@@ -34,8 +56,15 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
 		return
 	}
+	if !validEmail(params.Email) {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+	if !cfg.checkPassword(w, params.Password) {
+		return
+	}
 	// hash password and handle error
-	hashedPassword, err := auth.HashPassword(params.Password)
+	hashedPassword, err := cfg.passwords.Hash(params.Password)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
 		return
@@ -51,12 +80,17 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user")
 		return
 	}
+	// the account is limited until the email is verified, sent in the
+	// background so a slow mail server doesn't hold up signing up. A failed
+	// send can be retried through the resend endpoint
+	go func() {
+		err := cfg.sendVerificationEmail(user)
+		if err != nil {
+			log.Printf("Couldn't send verification email to user %d: %s", user.ID, err)
+		}
+	}()
 	// all checks passed, send response with proper data
 	respondWithJSON(w, http.StatusCreated, response{
-		User: User{
-			ID:          user.ID,
-			Email:       user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		},
+		User: userFromDB(user),
 	})
 }
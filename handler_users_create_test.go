@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/yuheng-liu/chirpy/internal/mail"
+)
+
+// mailer that holds every send until released
+type blockingMailer struct {
+	sent    chan mail.Message
+	release chan struct{}
+}
+
+func (mailer *blockingMailer) Send(msg mail.Message) error {
+	<-mailer.release
+	mailer.sent <- msg
+	return nil
+}
+
+func TestUsersCreateDoesNotWaitForMail(t *testing.T) {
+	cfg := newTestResetConfig(t, t.TempDir())
+	mailer := &blockingMailer{sent: make(chan mail.Message, 1), release: make(chan struct{})}
+	cfg.mailer = mailer
+
+	done := make(chan int)
+	go func() {
+		done <- post(cfg.handlerUsersCreate, `{"email":"a@example.com","password":"correct horse battery"}`).Code
+	}()
+	select {
+	case code := <-done:
+		if code != http.StatusCreated {
+			t.Fatalf("status %d, want 201", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("signing up waited for the verification email")
+	}
+
+	close(mailer.release)
+	select {
+	case msg := <-mailer.sent:
+		if msg.To != "a@example.com" {
+			t.Errorf("verification email sent to %s", msg.To)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no verification email was sent")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yuheng-liu/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handlerUsersRoles(w http.ResponseWriter, r *http.Request) {
+	// for converting request json to local struct
+	type parameters struct {
+		Roles []string `json:"roles"`
+	}
+	// convert user ID from the url to int
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	// decoding json to struct and handle error
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	roles, err := parseRoles(params.Roles)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// replace the user's roles in db, handle error
+	user, err := cfg.DB.SetUserRoles(userID, roles)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find user")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update roles")
+		return
+	}
+	// RequireRole loads the user from db on every request, so the change applies
+	// right away, the roles claim in tokens issued before it is only informational
+	respondWithJSON(w, http.StatusOK, userFromDB(user))
+}
+
+// validate role names, every user keeps the user role and duplicates are dropped
+func parseRoles(names []string) ([]database.Role, error) {
+	roles := []database.Role{database.RoleUser}
+	seen := map[database.Role]bool{database.RoleUser: true}
+	for _, name := range names {
+		role, err := database.ParseRole(name)
+		if err != nil {
+			return nil, errors.New("Invalid role " + strconv.Quote(name))
+		}
+		if seen[role] {
+			continue
+		}
+		seen[role] = true
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// convert roles to the strings embedded in jwts
+func roleNames(roles []database.Role) []string {
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, string(role))
+	}
+	return names
+}
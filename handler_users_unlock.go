@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yuheng-liu/chirpy/internal/database"
+)
+
+// lift a lockout from too many failed logins, for users locked out by a guesser
+func (cfg *apiConfig) handlerUsersUnlock(w http.ResponseWriter, r *http.Request) {
+	// convert user ID from the url to int
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	// failures are counted by email, look it up
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusNotFound, "Couldn't find user")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user")
+		return
+	}
+	err = cfg.lockout.Unlock(user.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unlock user")
+		return
+	}
+	// all checks passed, send response without any body
+	respondWithJSON(w, http.StatusOK, struct{}{})
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
-	"strconv"
 
 	"github.com/yuheng-liu/chirpy/internal/auth"
+	"github.com/yuheng-liu/chirpy/internal/database"
 )
 
 func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request) {
@@ -18,50 +20,76 @@ func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request)
 	type response struct {
 		User
 	}
-	// retrieve the jwt from request header
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
+	// user authenticated by the auth middleware
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
 		return
 	}
-	// check if retrieved jwt is valid, get back user ID as subject value
-	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT")
-		return
-	}
 	// decoding json to struct and handle error
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
 		return
 	}
-	// hash password and handle error
-	hashedPassword, err := auth.HashPassword(params.Password)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
+	if !validEmail(params.Email) {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address")
 		return
 	}
-	// convert user ID to int
-	userIDInt, err := strconv.Atoi(subject)
+	// a new email has to be verified again
+	emailChanged := params.Email != user.Email
+	// a changed password logs out everywhere, the old one may have leaked
+	_, err = cfg.passwords.Check(params.Password, user.HashedPassword)
+	passwordChanged := err != nil
+	// passwords from before the policy can be kept, new ones have to follow it
+	if passwordChanged && !cfg.checkPassword(w, params.Password) {
+		return
+	}
+	// hash password and handle error
+	hashedPassword, err := cfg.passwords.Hash(params.Password)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't parse user ID")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password")
 		return
 	}
 	// updates user with new values within db
-	user, err := cfg.DB.UpdateUser(userIDInt, params.Email, hashedPassword)
+	user, err = cfg.DB.UpdateUser(user.ID, params.Email, hashedPassword)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create user")
+		// another user already has the email
+		if errors.Is(err, database.ErrAlreadyExists) {
+			respondWithError(w, http.StatusConflict, "Email is already in use")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user")
 		return
 	}
+	if passwordChanged {
+		_, err = cfg.DB.RevokeUserSessions(user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions")
+			return
+		}
+		user, err = cfg.DB.InvalidateUserTokens(user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke access tokens")
+			return
+		}
+		// api keys could have been made by whoever got into the account
+		_, err = cfg.DB.RevokeUserAPIKeys(user.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke api keys")
+			return
+		}
+	}
+	if emailChanged {
+		err = cfg.sendVerificationEmail(user)
+		if err != nil {
+			log.Printf("Couldn't send verification email to user %d: %s", user.ID, err)
+		}
+	}
 	// all checks passed, send response with proper data
 	respondWithJSON(w, http.StatusOK, response{
-		User: User{
-			ID:          user.ID,
-			Email:       user.Email,
-			IsChirpyRed: user.IsChirpyRed,
-		},
+		User: userFromDB(user),
 	})
 }
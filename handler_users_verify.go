@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/yuheng-liu/chirpy/internal/auth"
+	"github.com/yuheng-liu/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handlerUsersVerify(w http.ResponseWriter, r *http.Request) {
+	// for converting request json to local struct
+	type parameters struct {
+		Token string `json:"token"`
+	}
+	// decoding json to struct and handle error
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	// check the token was issued by us and hasn't expired
+	userID, email, err := auth.ParseEmailVerificationToken(params.Token, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Verification token is invalid")
+		return
+	}
+	user, err := cfg.DB.GetUser(userID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusBadRequest, "Verification token is invalid")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user")
+		return
+	}
+	// a token is spent once its email is verified, or useless once the email changed
+	if user.Email == email && user.EmailVerified {
+		respondWithError(w, http.StatusConflict, "Verification token was already used")
+		return
+	}
+	user, err = cfg.DB.VerifyUserEmail(userID, email)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, http.StatusBadRequest, "Verification token is invalid")
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email")
+		return
+	}
+	// all checks passed, send response with the verified user
+	respondWithJSON(w, http.StatusOK, userFromDB(user))
+}
+
+// send another verification email, for users who lost theirs or let it expire
+func (cfg *apiConfig) handlerUsersVerifyResend(w http.ResponseWriter, r *http.Request) {
+	// user authenticated by the auth middleware
+	user, ok := auth.UserFromContext(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT")
+		return
+	}
+	if user.EmailVerified {
+		respondWithError(w, http.StatusConflict, "Email is already verified")
+		return
+	}
+	err := cfg.sendVerificationEmail(user)
+	if err != nil {
+		log.Printf("Couldn't send verification email to user %d: %s", user.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email")
+		return
+	}
+	// all checks passed, send response without any body
+	respondWithJSON(w, http.StatusAccepted, struct{}{})
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// every user api key starts with this, so keys can be told apart from jwts
+// and found by secret scanners
+const apiKeyPrefix = "chirpy_"
+
+var ErrNoUserAPIKey = errors.New("no user api key in request")
+
+// MakeAPIKey - new random api key for a user, and the start of it that is
+// shown when listing keys. Only the key's hash should be stored
+func MakeAPIKey() (string, string, error) {
+	id, err := randomHex(4)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", "", err
+	}
+	prefix := apiKeyPrefix + id
+	return prefix + "_" + secret, prefix, nil
+}
+
+// HashAPIKey - hash api keys are looked up by
+func HashAPIKey(key string) string {
+	return hashOpaqueToken(key)
+}
+
+// GetUserAPIKey - returns a user's api key from the request header, sent as
+// "ApiKey <key>" or as "Bearer <key>" for clients that only support bearer
+// tokens
+func GetUserAPIKey(headers http.Header) (string, error) {
+	key, err := GetAPIKey(headers)
+	if err != nil {
+		key, err = GetBearerToken(headers)
+	}
+	if err != nil || !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", ErrNoUserAPIKey
+	}
+	return key, nil
+}
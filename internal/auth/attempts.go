@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"container/heap"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/yuheng-liu/chirpy/internal/database"
+)
+
+// AttemptStore - where failed logins are counted, MemoryAttempts keeps them
+// on a single node, a database.Store shares them between nodes and restarts
+type AttemptStore interface {
+	GetLoginAttempts(key string) (database.LoginAttempts, error)
+	RecordLoginFailure(key string, at, resetBefore time.Time) (database.LoginAttempts, error)
+	ClearLoginAttempts(key string) error
+	DeleteStaleLoginAttempts(cutoff time.Time) (int, error)
+}
+
+// MemoryAttempts - AttemptStore kept in memory, lost on restart
+//
+// bounded in size, when full streaks that already reset are dropped first,
+// then the one that failed longest ago, which is the closest to unlocking
+type MemoryAttempts struct {
+	mu         *sync.Mutex
+	maxEntries int
+	attempts   map[string]database.LoginAttempts
+	// same streaks ordered by LastFailedAt, for evicting and pruning
+	byLastFailure *timeHeap
+}
+
+// NewMemoryAttempts - creates an empty in memory AttemptStore holding at most
+// maxEntries failure streaks
+func NewMemoryAttempts(maxEntries int) *MemoryAttempts {
+	return &MemoryAttempts{
+		mu:            &sync.Mutex{},
+		maxEntries:    maxEntries,
+		attempts:      map[string]database.LoginAttempts{},
+		byLastFailure: &timeHeap{},
+	}
+}
+
+func (store *MemoryAttempts) GetLoginAttempts(key string) (database.LoginAttempts, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	attempts, ok := store.attempts[key]
+	if !ok {
+		return database.LoginAttempts{Key: key}, nil
+	}
+	return attempts, nil
+}
+
+func (store *MemoryAttempts) RecordLoginFailure(key string, at, resetBefore time.Time) (database.LoginAttempts, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	attempts, ok := store.attempts[key]
+	if !ok {
+		store.makeRoomLocked(resetBefore)
+	}
+	if attempts.LastFailedAt.Before(resetBefore) {
+		attempts.Failures = 0
+	}
+	attempts.Key = key
+	attempts.Failures++
+	attempts.LastFailedAt = at.UTC()
+	store.attempts[key] = attempts
+	// the item of the previous failure is stale now, skipped when it comes up
+	heap.Push(store.byLastFailure, timedKey{key: key, at: attempts.LastFailedAt})
+	if store.byLastFailure.Len() > 2*store.maxEntries {
+		rebuilt := make(timeHeap, 0, len(store.attempts))
+		for key, attempts := range store.attempts {
+			rebuilt = append(rebuilt, timedKey{key: key, at: attempts.LastFailedAt})
+		}
+		heap.Init(&rebuilt)
+		store.byLastFailure = &rebuilt
+	}
+	return attempts, nil
+}
+
+// make room for one more streak, dropping the oldest if none had reset
+func (store *MemoryAttempts) makeRoomLocked(resetBefore time.Time) {
+	if len(store.attempts) < store.maxEntries {
+		return
+	}
+	store.pruneLocked(resetBefore)
+	for len(store.attempts) >= store.maxEntries && store.byLastFailure.Len() > 0 {
+		item := heap.Pop(store.byLastFailure).(timedKey)
+		if store.currentLocked(item) {
+			delete(store.attempts, item.key)
+			log.Printf("Login attempt store is full, dropped %s", item.key)
+		}
+	}
+}
+
+// drops streaks that last failed before cutoff, returns how many
+func (store *MemoryAttempts) pruneLocked(cutoff time.Time) int {
+	removed := 0
+	for store.byLastFailure.Len() > 0 {
+		item := (*store.byLastFailure)[0]
+		if !item.at.Before(cutoff) {
+			break
+		}
+		heap.Pop(store.byLastFailure)
+		if store.currentLocked(item) {
+			delete(store.attempts, item.key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// whether the heap item is for the latest failure of a streak still held
+func (store *MemoryAttempts) currentLocked(item timedKey) bool {
+	attempts, ok := store.attempts[item.key]
+	return ok && attempts.LastFailedAt.Equal(item.at)
+}
+
+// Len - number of failure streaks currently held
+func (store *MemoryAttempts) Len() int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return len(store.attempts)
+}
+
+func (store *MemoryAttempts) ClearLoginAttempts(key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.attempts, key)
+	return nil
+}
+
+func (store *MemoryAttempts) DeleteStaleLoginAttempts(cutoff time.Time) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.pruneLocked(cutoff), nil
+}
+
+// compile time checks that both stores satisfy AttemptStore
+var (
+	_ AttemptStore = (*MemoryAttempts)(nil)
+	_ AttemptStore = (database.Store)(nil)
+)
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemoryAttemptsBounded(t *testing.T) {
+	store := NewMemoryAttempts(3)
+	start := time.Now()
+	resetBefore := start.Add(-time.Hour)
+	for i := 0; i < 3; i++ {
+		_, err := store.RecordLoginFailure(fmt.Sprintf("key%d", i), start.Add(time.Duration(i)*time.Second), resetBefore)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a failure for a tracked key doesn't need room
+	if _, err := store.RecordLoginFailure("key0", start.Add(3*time.Second), resetBefore); err != nil {
+		t.Fatal(err)
+	}
+	// key1 failed longest ago now and goes first
+	if _, err := store.RecordLoginFailure("key3", start.Add(4*time.Second), resetBefore); err != nil {
+		t.Fatal(err)
+	}
+	if n := store.Len(); n != 3 {
+		t.Fatalf("store holds %d streaks, want 3", n)
+	}
+	for key, want := range map[string]int{"key0": 2, "key1": 0, "key2": 1, "key3": 1} {
+		attempts, err := store.GetLoginAttempts(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attempts.Failures != want {
+			t.Errorf("%s has %d failures, want %d", key, attempts.Failures, want)
+		}
+	}
+}
+
+func TestMemoryAttemptsDropsResetStreaksFirst(t *testing.T) {
+	store := NewMemoryAttempts(3)
+	start := time.Now()
+	store.RecordLoginFailure("old1", start, start.Add(-time.Hour))
+	store.RecordLoginFailure("old2", start.Add(time.Second), start.Add(-time.Hour))
+	store.RecordLoginFailure("recent", start.Add(time.Minute), start.Add(-time.Hour))
+	// both old streaks would start over anyway, making room drops them together
+	resetBefore := start.Add(30 * time.Second)
+	store.RecordLoginFailure("new", start.Add(2*time.Minute), resetBefore)
+	if n := store.Len(); n != 2 {
+		t.Fatalf("store holds %d streaks, want 2", n)
+	}
+	for key, want := range map[string]int{"old1": 0, "old2": 0, "recent": 1, "new": 1} {
+		attempts, _ := store.GetLoginAttempts(key)
+		if attempts.Failures != want {
+			t.Errorf("%s has %d failures, want %d", key, attempts.Failures, want)
+		}
+	}
+}
+
+func TestMemoryAttemptsDeleteStaleSkipsRefailed(t *testing.T) {
+	store := NewMemoryAttempts(10)
+	start := time.Now()
+	resetBefore := start.Add(-time.Hour)
+	store.RecordLoginFailure("a", start, resetBefore)
+	store.RecordLoginFailure("b", start.Add(time.Second), resetBefore)
+	// a failed again, its first failure no longer makes it stale
+	store.RecordLoginFailure("a", start.Add(time.Minute), resetBefore)
+	store.ClearLoginAttempts("b")
+	store.RecordLoginFailure("c", start.Add(2*time.Second), resetBefore)
+
+	removed, err := store.DeleteStaleLoginAttempts(start.Add(30 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("removed %d streaks, want 1", removed)
+	}
+	for key, want := range map[string]int{"a": 2, "b": 0, "c": 0} {
+		attempts, _ := store.GetLoginAttempts(key)
+		if attempts.Failures != want {
+			t.Errorf("%s has %d failures, want %d", key, attempts.Failures, want)
+		}
+	}
+}
+
+// keys failing over and over leave stale heap items, they must not pile up
+func TestMemoryAttemptsHeapStaysBounded(t *testing.T) {
+	store := NewMemoryAttempts(10)
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		store.RecordLoginFailure(fmt.Sprintf("key%d", i%20), start.Add(time.Duration(i)*time.Millisecond), start.Add(-time.Hour))
+	}
+	if n := store.Len(); n != 10 {
+		t.Errorf("store holds %d streaks, want 10", n)
+	}
+	if n := store.byLastFailure.Len(); n > 20 {
+		t.Errorf("heap holds %d items for 10 streaks", n)
+	}
+	// the ten keys that failed last are kept
+	for i := 10; i < 20; i++ {
+		attempts, _ := store.GetLoginAttempts(fmt.Sprintf("key%d", i))
+		if attempts.Failures == 0 {
+			t.Errorf("key%d was dropped", i)
+		}
+	}
+}
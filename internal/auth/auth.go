@@ -1,15 +1,16 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // new type to differentiate between different token types
@@ -18,121 +19,149 @@ type TokenType string
 const (
 	// TokenTypeAccess -
 	TokenTypeAccess TokenType = "chirpy-access"
-	// TokenTypeRefresh -
-	TokenTypeRefresh TokenType = "chirpy-refresh"
+	// TokenTypeEmailVerification - proves the holder received mail sent to an email
+	TokenTypeEmailVerification TokenType = "chirpy-verify-email"
+	// TokenTypeMFAChallenge - proves the holder knew the password of a user who
+	// still has to give their second factor
+	TokenTypeMFAChallenge TokenType = "chirpy-mfa-challenge"
 )
 
 var ErrNoAuthHeaderIncluded = errors.New("not auth header included in request")
 
-// HashPassword - generate hash using bcrypt
-func HashPassword(password string) (string, error) {
-	dat, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-	return string(dat), nil
+func init() {
+	// issued at is compared against a user's tokens valid after cutoff, whole
+	// seconds would leave tokens issued just before a logout valid
+	jwt.TimePrecision = time.Microsecond
 }
 
-// CheckPasswordHash - compare hash of passwords
-func CheckPasswordHash(password, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+// Claims - contents of the jwts issued by chirpy
+type Claims struct {
+	// roles the user had when the token was issued, they may have changed since
+	Roles []string `json:"roles,omitempty"`
+	// session the token was issued for, 0 if it doesn't belong to one
+	SessionID int `json:"sid,omitempty"`
+	// email the token was sent to, only in email verification tokens
+	Email string `json:"email,omitempty"`
+	jwt.RegisteredClaims
 }
 
-// MakeJWT - creates new jwt based on userID & tokenSecret
-func MakeJWT(userID int, tokenSecret string, expiresIn time.Duration, tokenType TokenType) (string, error) {
-	// key written in "jwt.env" file
-	signingKey := []byte(tokenSecret)
-	// create new token using jwt library, specifying signing method and claims
-	// registered claims are standardized values to external libraries
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		// which application issued the JWT?
-		Issuer: string(tokenType),
-		// when was the JWT issued?
-		IssuedAt: jwt.NewNumericDate(time.Now().UTC()),
-		// until what date/time can the JWT be accepted?
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		// who is the user subject of the JWT?
-		Subject: fmt.Sprintf("%d", userID),
+// MakeJWT - creates new jwt for userID and their session signed with the
+// current signing key, returns the token and when it expires. The roles are
+// informational for services verifying the token, chirpy itself checks the
+// roles of the user loaded from the db so changes apply right away
+//
+// there is no jti claim, single tokens can't be revoked: a logout revokes the
+// session of the token through the denylist, logging out everywhere moves the
+// user's TokensValidAfter
+func MakeJWT(userID, sessionID int, roles []string, keys *Keys, expiresIn time.Duration, tokenType TokenType) (string, time.Time, error) {
+	// same precision as the claim, so the returned time matches the token
+	now := time.Now().UTC().Truncate(jwt.TimePrecision)
+	expiresAt := now.Add(expiresIn)
+	// create new token claims, registered claims are standardized values to
+	// external libraries
+	token, err := keys.sign(Claims{
+		Roles:     roles,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			// which application issued the JWT?
+			Issuer: string(tokenType),
+			// when was the JWT issued?
+			IssuedAt: jwt.NewNumericDate(now),
+			// until what date/time can the JWT be accepted?
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			// who is the user subject of the JWT?
+			Subject: fmt.Sprintf("%d", userID),
+		},
 	})
-	// sign the token with secret key
-	return token.SignedString(signingKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
 }
 
-// RefreshToken - generate a new token based on the refresh token
-func RefreshToken(tokenString, tokenSecret string) (string, error) {
+// ParseJWT - check if jwt token is signed by one of the keys, unexpired and of
+// the expected type, returns its claims
+func ParseJWT(tokenString string, keys *Keys, tokenType TokenType) (Claims, error) {
 	// use claims of received jwt to check if it's same as local data
-	claimsStruct := jwt.RegisteredClaims{}
+	claimsStruct := Claims{}
 	// retrieve token using library function with appropriate params
-	token, err := jwt.ParseWithClaims(
+	_, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		keys.keyFunc,
 	)
 	if err != nil {
-		return "", err
+		return Claims{}, err
 	}
-	// get the subject field value from request auth header
-	userIDString, err := token.Claims.GetSubject()
-	if err != nil {
-		return "", err
+	// check the issuer, so jwt refresh tokens handed out by older versions
+	// can't be used as access tokens
+	if claimsStruct.Issuer != string(tokenType) {
+		return Claims{}, errors.New("invalid issuer")
 	}
-	// get issuer field value from request auth header
-	issuer, err := token.Claims.GetIssuer()
+	return claimsStruct, nil
+}
+
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// ValidateJWT - check if access token satisfies proper formatting and hasn't
+// been revoked, returns its claims if valid. ErrDenylistIncomplete comes with
+// the claims, the session of the token then has to be checked in the db
+func ValidateJWT(tokenString string, keys *Keys, denylist *Denylist) (Claims, error) {
+	claims, err := ParseJWT(tokenString, keys, TokenTypeAccess)
 	if err != nil {
-		return "", err
+		return Claims{}, err
 	}
-	// check if issuer is for refresh token and not access token
-	if issuer != string(TokenTypeRefresh) {
-		return "", errors.New("invalid issuer")
+	revoked, err := denylist.IsRevoked(claims)
+	if revoked {
+		return Claims{}, ErrTokenRevoked
 	}
-	// prepare to generate new jwt with same userID
-	userID, err := strconv.Atoi(userIDString)
-	if err != nil {
-		return "", err
-	}
-	// generate a new jwt based on the same userID and tokenSecret, but at different timing
-	newToken, err := MakeJWT(
-		userID,
-		tokenSecret,
-		time.Hour,
-		TokenTypeAccess,
-	)
 	if err != nil {
-		return "", err
+		return claims, err
 	}
-	// all checks passed, return the newly generate token
-	return newToken, nil
+	// all checks passed, return the claims
+	return claims, nil
 }
 
-// ValidateJWT - check if jwt token satisfies proper formatting, return user id if valid
-func ValidateJWT(tokenString, tokenSecret string) (string, error) {
-	// use claims of received jwt to check if it's same as local data
-	claimsStruct := jwt.RegisteredClaims{}
-	// retrieve token using library function with appropriate params
-	token, err := jwt.ParseWithClaims(
-		tokenString,
-		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
-	)
-	if err != nil {
-		return "", err
-	}
-	// get the subject field value from request auth header
-	userIDString, err := token.Claims.GetSubject()
-	if err != nil {
-		return "", err
-	}
-	// get issuer field value from request auth header
-	issuer, err := token.Claims.GetIssuer()
+// random id for the jti claim
+func newTokenID() (string, error) {
+	return randomHex(16)
+}
+
+// size random bytes, hex encoded
+func randomHex(size int) (string, error) {
+	dat := make([]byte, size)
+	_, err := rand.Read(dat)
 	if err != nil {
 		return "", err
 	}
-	// check if issuer is for access token and not refresh token
-	if issuer != string(TokenTypeAccess) {
-		return "", errors.New("invalid issuer")
-	}
-	// all checks passed, return embedded user ID
-	return userIDString, nil
+	return hex.EncodeToString(dat), nil
+}
+
+// MakeRefreshToken - random opaque refresh token, only its hash should be stored
+func MakeRefreshToken() (string, error) {
+	return randomHex(32)
+}
+
+// HashRefreshToken - hash refresh tokens are looked up by
+func HashRefreshToken(token string) string {
+	return hashOpaqueToken(token)
+}
+
+// MakePasswordResetToken - random single use token emailed to a user who
+// forgot their password, only its hash should be stored
+func MakePasswordResetToken() (string, error) {
+	return randomHex(32)
+}
+
+// HashPasswordResetToken - hash password reset tokens are looked up by
+func HashPasswordResetToken(token string) string {
+	return hashOpaqueToken(token)
+}
+
+// opaque tokens are random enough that a fast hash is safe
+func hashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 // GetBearerToken - returns the token within request header
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RangeFiles - BreachChecker reading a local copy of the Pwned Passwords
+// range files, dir holds one file per 5 character SHA-1 prefix (e.g.
+// "21BD1" or "21BD1.txt") with a "SUFFIX:COUNT" line per breached password
+//
+// only the prefix of a password's hash picks the file, the same k-anonymity
+// layout the online api uses, so the files can be fetched with its tools
+type RangeFiles struct {
+	dir string
+}
+
+// NewRangeFiles - checks passwords against the range files in dir
+func NewRangeFiles(dir string) (*RangeFiles, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New(dir + " is not a directory")
+	}
+	return &RangeFiles{dir: dir}, nil
+}
+
+func (files *RangeFiles) IsBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+	for _, name := range []string{prefix, prefix + ".txt"} {
+		breached, err := searchRangeFile(filepath.Join(files.dir, name), suffix)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		return breached, err
+	}
+	// no file for the prefix, nothing known about these passwords
+	return false, nil
+}
+
+func searchRangeFile(path, suffix string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineSuffix, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		// padding entries added by the api have a count of 0
+		if strings.EqualFold(lineSuffix, suffix) && count != "0" {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// compile time check that RangeFiles satisfies BreachChecker
+var _ BreachChecker = (*RangeFiles)(nil)
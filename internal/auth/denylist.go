@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"container/heap"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Denylist - access tokens revoked before they expire by session and used mfa
+// challenges, kept in memory and bounded in size
+//
+// entries only need to live as long as the tokens they block, so they are
+// dropped once expired; a revocation that doesn't fit in a full list is never
+// traded for another one, until it would have expired every session has to be
+// checked in the db instead
+type Denylist struct {
+	mu         *sync.Mutex
+	maxEntries int
+	// revocations were left out of the full list, the list can't answer for
+	// sessions it doesn't hold before this time
+	incompleteUntil time.Time
+	// key -> time the entry stops mattering
+	entries map[string]time.Time
+	// same entries ordered by expiry, for pruning
+	byExpiry *timeHeap
+}
+
+// NewDenylist - creates an empty denylist holding at most maxEntries entries
+func NewDenylist(maxEntries int) *Denylist {
+	return &Denylist{
+		mu:         &sync.Mutex{},
+		maxEntries: maxEntries,
+		entries:    map[string]time.Time{},
+		byExpiry:   &timeHeap{},
+	}
+}
+
+// ErrDenylistIncomplete - the denylist was full when a session was revoked, so
+// a session it doesn't hold may still be revoked
+var ErrDenylistIncomplete = errors.New("token denylist is incomplete")
+
+func sessionKey(sessionID int) string {
+	return "sid:" + strconv.Itoa(sessionID)
+}
+
+// RevokeSession - blocks every access token of the session, until is when the
+// last one issued so far expires
+func (denylist *Denylist) RevokeSession(sessionID int, until time.Time) {
+	denylist.mu.Lock()
+	defer denylist.mu.Unlock()
+
+	if !denylist.addLocked(sessionKey(sessionID), until, time.Now()) {
+		// dropping a live entry would let its tokens back in, keep them all
+		// and have sessions checked in the db until this one would expire
+		if until.After(denylist.incompleteUntil) {
+			denylist.incompleteUntil = until
+		}
+		log.Printf("Token denylist is full, checking sessions in the database until %s", denylist.incompleteUntil.Format(time.RFC3339))
+	}
+}
+
+// UseMFAChallenge - marks the challenge token with the id as used until it
+// expires, false if it was used before or the list is too full to remember it
+func (denylist *Denylist) UseMFAChallenge(tokenID string, until time.Time) bool {
+	denylist.mu.Lock()
+	defer denylist.mu.Unlock()
+
+	key := "mfa:" + tokenID
+	now := time.Now()
+	if denylist.activeLocked(key, now) {
+		return false
+	}
+	if !denylist.addLocked(key, until, now) {
+		log.Printf("Token denylist is full, refused an mfa challenge")
+		return false
+	}
+	return true
+}
+
+// IsRevoked - whether the session of the token has been revoked,
+// ErrDenylistIncomplete if the list can't tell
+func (denylist *Denylist) IsRevoked(claims Claims) (bool, error) {
+	denylist.mu.Lock()
+	defer denylist.mu.Unlock()
+
+	if claims.SessionID == 0 {
+		return false, nil
+	}
+	now := time.Now()
+	if denylist.activeLocked(sessionKey(claims.SessionID), now) {
+		return true, nil
+	}
+	if now.Before(denylist.incompleteUntil) {
+		return false, ErrDenylistIncomplete
+	}
+	return false, nil
+}
+
+func (denylist *Denylist) activeLocked(key string, now time.Time) bool {
+	expiresAt, ok := denylist.entries[key]
+	return ok && now.Before(expiresAt)
+}
+
+// stores the entry, false if the list is full and it would take the place of
+// another live one
+func (denylist *Denylist) addLocked(key string, expiresAt time.Time, now time.Time) bool {
+	if !now.Before(expiresAt) {
+		return true
+	}
+	denylist.pruneLocked(now)
+	old, ok := denylist.entries[key]
+	// a later expiry replaces the old entry, the stale heap item is skipped
+	// when it comes up
+	if ok && !expiresAt.After(old) {
+		return true
+	}
+	if !ok && len(denylist.entries) >= denylist.maxEntries {
+		return false
+	}
+	denylist.entries[key] = expiresAt
+	heap.Push(denylist.byExpiry, timedKey{key: key, at: expiresAt})
+	// extended entries leave stale items behind, rebuild before they pile up
+	if denylist.byExpiry.Len() > 2*denylist.maxEntries {
+		rebuilt := make(timeHeap, 0, len(denylist.entries))
+		for key, expiresAt := range denylist.entries {
+			rebuilt = append(rebuilt, timedKey{key: key, at: expiresAt})
+		}
+		heap.Init(&rebuilt)
+		denylist.byExpiry = &rebuilt
+	}
+	return true
+}
+
+// Prune - drops expired entries, also done on every addition
+func (denylist *Denylist) Prune() {
+	denylist.mu.Lock()
+	defer denylist.mu.Unlock()
+
+	denylist.pruneLocked(time.Now())
+}
+
+func (denylist *Denylist) pruneLocked(now time.Time) {
+	for denylist.byExpiry.Len() > 0 {
+		item := (*denylist.byExpiry)[0]
+		if now.Before(item.at) {
+			return
+		}
+		heap.Pop(denylist.byExpiry)
+		if denylist.entries[item.key].Equal(item.at) {
+			delete(denylist.entries, item.key)
+		}
+	}
+}
+
+// Len - number of entries currently held
+func (denylist *Denylist) Len() int {
+	denylist.mu.Lock()
+	defer denylist.mu.Unlock()
+
+	return len(denylist.entries)
+}
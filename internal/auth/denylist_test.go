@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yuheng-liu/chirpy/internal/database"
+)
+
+func TestDenylistFullKeepsLiveEntries(t *testing.T) {
+	denylist := NewDenylist(2)
+	denylist.RevokeSession(1, time.Now().Add(time.Minute))
+	denylist.RevokeSession(2, time.Now().Add(time.Hour))
+	denylist.RevokeSession(3, time.Now().Add(time.Hour))
+
+	for _, sessionID := range []int{1, 2} {
+		revoked, err := denylist.IsRevoked(Claims{SessionID: sessionID})
+		if err != nil || !revoked {
+			t.Errorf("IsRevoked(session %d) = %t, %v, want true", sessionID, revoked, err)
+		}
+	}
+	// neither the revoked session that didn't fit nor any other can be
+	// answered for
+	for _, sessionID := range []int{3, 4} {
+		if _, err := denylist.IsRevoked(Claims{SessionID: sessionID}); !errors.Is(err, ErrDenylistIncomplete) {
+			t.Errorf("IsRevoked(session %d) error = %v, want ErrDenylistIncomplete", sessionID, err)
+		}
+	}
+	if got := denylist.Len(); got != 2 {
+		t.Errorf("Len() = %d, want 2", got)
+	}
+}
+
+func TestDenylistCompleteAgainAfterOverflowExpires(t *testing.T) {
+	denylist := NewDenylist(1)
+	denylist.RevokeSession(1, time.Now().Add(time.Hour))
+	denylist.RevokeSession(2, time.Now().Add(50*time.Millisecond))
+	if _, err := denylist.IsRevoked(Claims{SessionID: 3}); !errors.Is(err, ErrDenylistIncomplete) {
+		t.Fatalf("IsRevoked() error = %v, want ErrDenylistIncomplete", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	revoked, err := denylist.IsRevoked(Claims{SessionID: 3})
+	if err != nil || revoked {
+		t.Errorf("IsRevoked() = %t, %v, want false", revoked, err)
+	}
+}
+
+// sessionStore - CredentialStore holding one user and its sessions
+type sessionStore struct {
+	user     database.User
+	sessions map[int]database.Session
+}
+
+func (s *sessionStore) GetUser(id int) (database.User, error) {
+	if id != s.user.ID {
+		return database.User{}, database.ErrNotExist
+	}
+	return s.user, nil
+}
+
+func (s *sessionStore) GetSession(id int) (database.Session, error) {
+	session, ok := s.sessions[id]
+	if !ok {
+		return database.Session{}, database.ErrNotExist
+	}
+	return session, nil
+}
+
+func (s *sessionStore) GetAPIKeyByHash(keyHash string) (database.APIKey, error) {
+	return database.APIKey{}, database.ErrNotExist
+}
+
+func (s *sessionStore) TouchAPIKey(keyHash string, usedAt time.Time) error {
+	return nil
+}
+
+func TestAuthenticateChecksSessionWhenDenylistIsFull(t *testing.T) {
+	keys := NewHMACKeys("test secret")
+	store := &sessionStore{
+		user: database.User{ID: 1},
+		sessions: map[int]database.Session{
+			1: {ID: 1, UserID: 1},
+			2: {ID: 2, UserID: 1, RevokedAt: time.Now()},
+		},
+	}
+	denylist := NewDenylist(1)
+	denylist.RevokeSession(9, time.Now().Add(time.Hour))
+	denylist.RevokeSession(2, time.Now().Add(time.Hour))
+	authn := NewAuthenticator(keys, denylist, store, nil)
+
+	tests := []struct {
+		sessionID int
+		want      error
+	}{
+		{1, nil},
+		{2, errRevokedToken},
+		// pruned sessions can't have live tokens
+		{3, errRevokedToken},
+	}
+	for _, tt := range tests {
+		token, _, err := MakeJWT(1, tt.sessionID, nil, keys, time.Hour, TokenTypeAccess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("GET", "/api/users", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		if _, err := authn.authenticate(r); !errors.Is(err, tt.want) {
+			t.Errorf("authenticate(session %d) error = %v, want %v", tt.sessionID, err, tt.want)
+		}
+	}
+}
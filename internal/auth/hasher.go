@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrEmptyPassword     = errors.New("password is empty")
+	ErrPasswordTooLong   = fmt.Errorf("password is longer than %d bytes", maxPasswordBytes)
+	ErrPasswordMismatch  = errors.New("password doesn't match")
+	ErrUnknownHashFormat = errors.New("password hash in an unknown format")
+)
+
+// PasswordHasher - one password hashing algorithm, hashes encode the
+// algorithm and its parameters so they can be checked after the settings change
+type PasswordHasher interface {
+	// Hash - hashes the password with the current parameters
+	Hash(password string) (string, error)
+	// Verify - whether the password matches a hash in this hasher's format
+	Verify(password, encoded string) (bool, error)
+	// Recognizes - whether the hash is in this hasher's format
+	Recognizes(encoded string) bool
+	// NeedsRehash - whether the hash is weaker than the current parameters make them
+	NeedsRehash(encoded string) bool
+}
+
+// Passwords - hashes new passwords with the current hasher and checks hashes
+// made by any of the hashers
+type Passwords struct {
+	current PasswordHasher
+	// including current
+	hashers []PasswordHasher
+}
+
+// NewPasswords - hashes with current, others are only used to check hashes
+// made before switching algorithms
+func NewPasswords(current PasswordHasher, others ...PasswordHasher) *Passwords {
+	return &Passwords{
+		current: current,
+		hashers: append([]PasswordHasher{current}, others...),
+	}
+}
+
+// Hash - hashes the password with the current hasher
+func (passwords *Passwords) Hash(password string) (string, error) {
+	if password == "" {
+		return "", ErrEmptyPassword
+	}
+	return passwords.current.Hash(password)
+}
+
+// Check - ErrPasswordMismatch unless the password matches the hash, on a match
+// returns whether the hash should be replaced by one from Hash
+func (passwords *Passwords) Check(password, encoded string) (bool, error) {
+	for _, hasher := range passwords.hashers {
+		if !hasher.Recognizes(encoded) {
+			continue
+		}
+		ok, err := hasher.Verify(password, encoded)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, ErrPasswordMismatch
+		}
+		return hasher != passwords.current || hasher.NeedsRehash(encoded), nil
+	}
+	return false, ErrUnknownHashFormat
+}
+
+// BcryptHasher - bcrypt at Cost, hashes look like "$2a$10$..."
+type BcryptHasher struct {
+	Cost int
+}
+
+func (hasher BcryptHasher) Hash(password string) (string, error) {
+	// bcrypt ignores everything past 72 bytes
+	if len(password) > maxPasswordBytes {
+		return "", ErrPasswordTooLong
+	}
+	dat, err := bcrypt.GenerateFromPassword([]byte(password), hasher.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(dat), nil
+}
+
+func (hasher BcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (hasher BcryptHasher) Recognizes(encoded string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(encoded, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (hasher BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < hasher.Cost
+}
+
+// Argon2idHasher - argon2id, hashes are in the PHC string format like
+// "$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>"
+type Argon2idHasher struct {
+	// in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2id - RFC 9106's second recommended option with fewer threads
+var DefaultArgon2id = Argon2idHasher{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+const argon2idPrefix = "$argon2id$"
+
+func (hasher Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, hasher.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, hasher.Iterations, hasher.Memory, hasher.Parallelism, hasher.KeyLength)
+	return fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		hasher.Memory,
+		hasher.Iterations,
+		hasher.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// parameters, salt and key of an encoded hash
+type argon2idHash struct {
+	version int
+	params  Argon2idHasher
+	salt    []byte
+	key     []byte
+}
+
+func decodeArgon2id(encoded string) (argon2idHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return argon2idHash{}, ErrUnknownHashFormat
+	}
+	hash := argon2idHash{}
+	_, err := fmt.Sscanf(parts[2], "v=%d", &hash.version)
+	if err != nil {
+		return argon2idHash{}, ErrUnknownHashFormat
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &hash.params.Memory, &hash.params.Iterations, &hash.params.Parallelism)
+	if err != nil {
+		return argon2idHash{}, ErrUnknownHashFormat
+	}
+	hash.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return argon2idHash{}, ErrUnknownHashFormat
+	}
+	hash.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return argon2idHash{}, ErrUnknownHashFormat
+	}
+	hash.params.SaltLength = uint32(len(hash.salt))
+	hash.params.KeyLength = uint32(len(hash.key))
+	return hash, nil
+}
+
+func (hasher Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	hash, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	if hash.version != argon2.Version {
+		return false, fmt.Errorf("%w: argon2 version %d", ErrUnknownHashFormat, hash.version)
+	}
+	params := hash.params
+	key := argon2.IDKey([]byte(password), hash.salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return subtle.ConstantTimeCompare(key, hash.key) == 1, nil
+}
+
+func (hasher Argon2idHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+func (hasher Argon2idHasher) NeedsRehash(encoded string) bool {
+	hash, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	params := hash.params
+	return hash.version != argon2.Version ||
+		params.Memory < hasher.Memory ||
+		params.Iterations < hasher.Iterations ||
+		params.Parallelism < hasher.Parallelism ||
+		params.SaltLength < hasher.SaltLength ||
+		params.KeyLength < hasher.KeyLength
+}
+
+// compile time checks that both hashers satisfy PasswordHasher
+var (
+	_ PasswordHasher = BcryptHasher{}
+	_ PasswordHasher = Argon2idHasher{}
+)
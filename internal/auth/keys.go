@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrNoSigningKey = errors.New("no private key to sign tokens with")
+	ErrUnknownKey   = errors.New("token signed with an unknown key")
+)
+
+// Key - one key tokens are signed or verified with
+type Key struct {
+	// id sent in the "kid" header, the key file name without its extension
+	ID     string
+	Method jwt.SigningMethod
+	// nil for keys that are only kept to verify tokens issued before a rotation
+	private crypto.Signer
+	public  crypto.PublicKey
+	// shared secret, only for HS256
+	secret []byte
+}
+
+// CanSign - whether tokens can be signed with the key
+func (key Key) CanSign() bool {
+	return key.private != nil || key.secret != nil
+}
+
+func (key Key) signingKey() interface{} {
+	if key.secret != nil {
+		return key.secret
+	}
+	return key.private
+}
+
+func (key Key) verificationKey() interface{} {
+	if key.secret != nil {
+		return key.secret
+	}
+	return key.public
+}
+
+// Keys - the key new tokens are signed with and every key tokens are accepted from
+type Keys struct {
+	signing Key
+	// by kid, includes the signing key
+	verify map[string]Key
+}
+
+// NewHMACKeys - signs and verifies HS256 tokens with a shared secret, every
+// service verifying tokens has to hold the secret, so it is not published in the jwks
+func NewHMACKeys(secret string) *Keys {
+	key := Key{
+		Method: jwt.SigningMethodHS256,
+		secret: []byte(secret),
+	}
+	return &Keys{
+		signing: key,
+		verify:  map[string]Key{"": key},
+	}
+}
+
+// LoadKeys - reads every *.pem file in dir, private keys (RSA for RS256,
+// Ed25519 for EdDSA) can sign and public keys only verify, the kid of a key
+// is its file name without ".pem"
+//
+// signingKID picks the key new tokens are signed with, if empty the private
+// key whose name sorts last is used, so naming keys by date ("2024-06.pem")
+// rotates to a new key as soon as it is added. Old keys stay valid until their
+// file is removed, which should wait until the last token they signed expired
+func LoadKeys(dir, signingKID string) (*Keys, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	keys := &Keys{verify: map[string]Key{}}
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := loadKeyFile(path, kid)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys.verify[kid] = key
+		// sorted, so the last private key wins unless one was asked for
+		if key.CanSign() && (signingKID == "" || signingKID == kid) {
+			keys.signing = key
+		}
+	}
+	if !keys.signing.CanSign() {
+		if signingKID != "" {
+			return nil, fmt.Errorf("%w: %q in %s", ErrNoSigningKey, signingKID, dir)
+		}
+		return nil, fmt.Errorf("%w in %s", ErrNoSigningKey, dir)
+	}
+	return keys, nil
+}
+
+func loadKeyFile(path, kid string) (Key, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, err
+	}
+	block, _ := pem.Decode(dat)
+	if block == nil {
+		return Key{}, errors.New("no pem block found")
+	}
+	key := Key{ID: kid}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return Key{}, err
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return Key{}, errors.New("unsupported private key type")
+		}
+		key.private = signer
+		key.public = signer.Public()
+	case "RSA PRIVATE KEY":
+		parsed, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return Key{}, err
+		}
+		key.private = parsed
+		key.public = parsed.Public()
+	case "PUBLIC KEY":
+		key.public, err = x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return Key{}, err
+		}
+	default:
+		return Key{}, fmt.Errorf("unsupported pem block %q", block.Type)
+	}
+	switch key.public.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return Key{}, errors.New("unsupported key type, use RSA or Ed25519")
+	}
+	return key, nil
+}
+
+// sign the claims with the signing key, setting its kid
+func (keys *Keys) sign(claims jwt.Claims) (string, error) {
+	if !keys.signing.CanSign() {
+		return "", ErrNoSigningKey
+	}
+	token := jwt.NewWithClaims(keys.signing.Method, claims)
+	if keys.signing.ID != "" {
+		token.Header["kid"] = keys.signing.ID
+	}
+	return token.SignedString(keys.signing.signingKey())
+}
+
+// keyFunc for jwt.Parse, picks the key by kid and makes sure the token uses
+// that key's algorithm, so a public key can't be used as an HMAC secret
+func (keys *Keys) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := keys.verify[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.verificationKey(), nil
+}
+
+// JWK - public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA modulus and exponent
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519 curve and public key
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS - set of public keys, served so other services can verify tokens
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS - public halves of every asymmetric key tokens are accepted from,
+// sorted by kid, shared secrets are never included
+func (keys *Keys) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	kids := make([]string, 0, len(keys.verify))
+	for kid := range keys.verify {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	for _, kid := range kids {
+		key := keys.verify[kid]
+		jwk := JWK{
+			KeyID:     key.ID,
+			Use:       "sig",
+			Algorithm: key.Method.Alg(),
+		}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
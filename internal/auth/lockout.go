@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"strings"
+	"time"
+)
+
+// LockoutPolicy - when failed logins for one key lock it
+type LockoutPolicy struct {
+	// failures in a row allowed before the key is locked
+	Threshold int
+	// how long reaching the threshold locks the key, doubled with every
+	// further failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// a streak is forgotten after this long without failures
+	ResetAfter time.Duration
+}
+
+// delay - how long the key is locked after its last failure
+func (policy LockoutPolicy) delay(failures int) time.Duration {
+	if failures < policy.Threshold {
+		return 0
+	}
+	delay := policy.BaseDelay
+	for i := policy.Threshold; i < failures && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxDelay {
+		return policy.MaxDelay
+	}
+	return delay
+}
+
+var (
+	// DefaultAccountPolicy - locks an account after 5 failures for 1 minute
+	// up to an hour, so a guesser gets only a few dozen tries a day
+	DefaultAccountPolicy = LockoutPolicy{
+		Threshold:  5,
+		BaseDelay:  time.Minute,
+		MaxDelay:   time.Hour,
+		ResetAfter: 24 * time.Hour,
+	}
+	// DefaultAddressPolicy - locks an address guessing across many accounts,
+	// leaving room for several users behind one NAT
+	DefaultAddressPolicy = LockoutPolicy{
+		Threshold:  20,
+		BaseDelay:  time.Minute,
+		MaxDelay:   time.Hour,
+		ResetAfter: 24 * time.Hour,
+	}
+)
+
+// Lockout - tracks failed logins per account and per address, locking either
+// with exponential backoff once it fails too often
+type Lockout struct {
+	store   AttemptStore
+	account LockoutPolicy
+	address LockoutPolicy
+}
+
+// NewLockout - creates a lockout counting failures in store
+func NewLockout(store AttemptStore, account, address LockoutPolicy) *Lockout {
+	return &Lockout{
+		store:   store,
+		account: account,
+		address: address,
+	}
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func addressKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check - how long until a login for email from ip may be attempted, 0 if it
+// may be attempted now
+func (lockout *Lockout) Check(email, ip string) (time.Duration, error) {
+	now := time.Now()
+	accountWait, err := lockout.wait(accountKey(email), lockout.account, now)
+	if err != nil {
+		return 0, err
+	}
+	addressWait, err := lockout.wait(addressKey(ip), lockout.address, now)
+	if err != nil {
+		return 0, err
+	}
+	return max(accountWait, addressWait), nil
+}
+
+func (lockout *Lockout) wait(key string, policy LockoutPolicy, now time.Time) (time.Duration, error) {
+	attempts, err := lockout.store.GetLoginAttempts(key)
+	if err != nil {
+		return 0, err
+	}
+	// a streak that was reset no longer locks
+	if attempts.LastFailedAt.Before(now.Add(-policy.ResetAfter)) {
+		return 0, nil
+	}
+	lockedUntil := attempts.LastFailedAt.Add(policy.delay(attempts.Failures))
+	if !now.Before(lockedUntil) {
+		return 0, nil
+	}
+	return lockedUntil.Sub(now), nil
+}
+
+// Failure - counts a failed login for email from ip
+func (lockout *Lockout) Failure(email, ip string) error {
+	now := time.Now()
+	_, err := lockout.store.RecordLoginFailure(accountKey(email), now, now.Add(-lockout.account.ResetAfter))
+	if err != nil {
+		return err
+	}
+	_, err = lockout.store.RecordLoginFailure(addressKey(ip), now, now.Add(-lockout.address.ResetAfter))
+	return err
+}
+
+// Success - ends the failure streak of the account, the address keeps its
+// count so logging into one's own account doesn't reset guessing at others
+func (lockout *Lockout) Success(email string) error {
+	return lockout.store.ClearLoginAttempts(accountKey(email))
+}
+
+// Unlock - lets the account log in again right away
+func (lockout *Lockout) Unlock(email string) error {
+	return lockout.store.ClearLoginAttempts(accountKey(email))
+}
+
+// Prune - drops streaks that were already reset, returns how many were dropped
+func (lockout *Lockout) Prune() (int, error) {
+	resetAfter := max(lockout.account.ResetAfter, lockout.address.ResetAfter)
+	return lockout.store.DeleteStaleLoginAttempts(time.Now().Add(-resetAfter))
+}
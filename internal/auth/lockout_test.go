@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+var testPolicy = LockoutPolicy{
+	Threshold:  3,
+	BaseDelay:  time.Minute,
+	MaxDelay:   5 * time.Minute,
+	ResetAfter: time.Hour,
+}
+
+func TestLockoutPolicyDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := testPolicy.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+// wait is measured from the last failure, allow for the time the test takes
+func assertWait(t *testing.T, lockout *Lockout, email, ip string, want time.Duration) {
+	t.Helper()
+	wait, err := lockout.Check(email, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait > want || wait < want-5*time.Second {
+		t.Errorf("Check(%s, %s) = %s, want about %s", email, ip, wait, want)
+	}
+}
+
+func TestLockoutBackoff(t *testing.T) {
+	lockout := NewLockout(NewMemoryAttempts(100), testPolicy, DefaultAddressPolicy)
+	for i := 0; i < 2; i++ {
+		if err := lockout.Failure("a@example.com", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertWait(t, lockout, "a@example.com", "10.0.0.1", 0)
+	lockout.Failure("a@example.com", "10.0.0.1")
+	assertWait(t, lockout, "a@example.com", "10.0.0.1", time.Minute)
+	// every further failure doubles the wait
+	lockout.Failure("a@example.com", "10.0.0.1")
+	assertWait(t, lockout, "a@example.com", "10.0.0.1", 2*time.Minute)
+	// the account is locked from every address, case doesn't matter
+	assertWait(t, lockout, " A@Example.com", "10.0.0.2", 2*time.Minute)
+	// other accounts aren't affected
+	assertWait(t, lockout, "b@example.com", "10.0.0.2", 0)
+
+	if err := lockout.Unlock("a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	assertWait(t, lockout, "a@example.com", "10.0.0.2", 0)
+}
+
+func TestLockoutAddress(t *testing.T) {
+	address := LockoutPolicy{Threshold: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, ResetAfter: time.Hour}
+	lockout := NewLockout(NewMemoryAttempts(100), DefaultAccountPolicy, address)
+	// guessing one password each across many accounts
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		lockout.Failure(email, "10.0.0.1")
+	}
+	assertWait(t, lockout, "d@example.com", "10.0.0.1", time.Minute)
+	assertWait(t, lockout, "d@example.com", "10.0.0.2", 0)
+	// logging into one's own account doesn't reset the address
+	if err := lockout.Success("a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	assertWait(t, lockout, "d@example.com", "10.0.0.1", time.Minute)
+}
+
+func TestLockoutStreakResets(t *testing.T) {
+	store := NewMemoryAttempts(100)
+	lockout := NewLockout(store, testPolicy, DefaultAddressPolicy)
+	// failures from longer ago than ResetAfter
+	old := time.Now().Add(-2 * time.Hour)
+	for i := 0; i < 5; i++ {
+		store.RecordLoginFailure(accountKey("a@example.com"), old, old.Add(-time.Hour))
+	}
+	assertWait(t, lockout, "a@example.com", "10.0.0.1", 0)
+	// the next failure starts a new streak instead of adding to the old one
+	lockout.Failure("a@example.com", "10.0.0.1")
+	attempts, err := store.GetLoginAttempts(accountKey("a@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Failures != 1 {
+		t.Errorf("streak has %d failures, want 1", attempts.Failures)
+	}
+	removed, err := lockout.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 0 {
+		t.Errorf("pruned %d current streaks", removed)
+	}
+}
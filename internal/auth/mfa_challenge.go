@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrInvalidMFAChallenge = errors.New("invalid mfa challenge token")
+
+// MakeMFAChallengeToken - creates a token for the second step of a login,
+// handed out once the password of the user with userID checked out
+func MakeMFAChallengeToken(userID int, keys *Keys, expiresIn time.Duration) (string, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	// same precision as the claim, so it compares with TokensValidAfter
+	now := time.Now().UTC().Truncate(jwt.TimePrecision)
+	return keys.sign(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeMFAChallenge),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Subject:   fmt.Sprintf("%d", userID),
+			ID:        tokenID,
+		},
+	})
+}
+
+// MFAChallenge - a checked challenge token
+type MFAChallenge struct {
+	// user logging in
+	UserID int
+	// so the challenge can only be answered once
+	TokenID   string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// ParseMFAChallengeToken - checks the token, returns the challenge with the id
+// of the user logging in
+func ParseMFAChallengeToken(tokenString string, keys *Keys) (MFAChallenge, error) {
+	claims, err := ParseJWT(tokenString, keys, TokenTypeMFAChallenge)
+	if err != nil {
+		return MFAChallenge{}, fmt.Errorf("%w: %w", ErrInvalidMFAChallenge, err)
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return MFAChallenge{}, ErrInvalidMFAChallenge
+	}
+	if claims.ID == "" || claims.IssuedAt == nil || claims.ExpiresAt == nil {
+		return MFAChallenge{}, ErrInvalidMFAChallenge
+	}
+	return MFAChallenge{
+		UserID:    userID,
+		TokenID:   claims.ID,
+		IssuedAt:  claims.IssuedAt.Time,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, nil
+}
@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/yuheng-liu/chirpy/internal/database"
+)
+
+// UserGetter - loads the user an access token was issued to, database.Store
+// satisfies it
+type UserGetter interface {
+	GetUser(id int) (database.User, error)
+}
+
+// CredentialStore - loads the users and api keys requests authenticate with,
+// database.Store satisfies it
+type CredentialStore interface {
+	UserGetter
+	GetSession(id int) (database.Session, error)
+	GetAPIKeyByHash(keyHash string) (database.APIKey, error)
+	TouchAPIKey(keyHash string, usedAt time.Time) error
+}
+
+// ErrorResponder - writes an error response, lets the middleware answer in the
+// same format as the handlers it wraps
+type ErrorResponder func(w http.ResponseWriter, code int, msg string)
+
+// Principal - the authenticated caller of a request
+type Principal struct {
+	// user loaded from the db, so roles and membership are current
+	User database.User
+	// claims of the access token the request carried, empty for api keys
+	Claims Claims
+	// nil unless the request was made with an api key
+	APIKey *database.APIKey
+}
+
+// key type for context values, unexported so other packages can't collide
+type contextKey int
+
+const (
+	principalKey contextKey = iota
+	apiKeyScopeKey
+)
+
+// WithPrincipal - returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+// PrincipalFromContext - the principal stored by the middleware, false if the
+// request is anonymous
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey).(Principal)
+	return principal, ok
+}
+
+// UserFromContext - the authenticated user, false if the request is anonymous
+func UserFromContext(ctx context.Context) (database.User, bool) {
+	principal, ok := PrincipalFromContext(ctx)
+	return principal.User, ok
+}
+
+// Authenticator - chi middleware that validates access tokens and puts the
+// principal into the request context
+type Authenticator struct {
+	keys     *Keys
+	denylist *Denylist
+	store    CredentialStore
+	respond  ErrorResponder
+}
+
+// NewAuthenticator - creates middleware validating tokens signed with one of
+// the keys and not revoked through the denylist, and api keys from the store,
+// errors are written with respond
+func NewAuthenticator(keys *Keys, denylist *Denylist, store CredentialStore, respond ErrorResponder) *Authenticator {
+	return &Authenticator{
+		keys:     keys,
+		denylist: denylist,
+		store:    store,
+		respond:  respond,
+	}
+}
+
+// how stale the last use of an api key may get, so busy keys aren't written
+// on every request
+const apiKeyTouchInterval = time.Minute
+
+// errors from authenticate, mapped to responses by Required
+var (
+	errMissingToken = errors.New("no access token")
+	errInvalidToken = errors.New("invalid access token")
+	errUnknownUser  = errors.New("token user does not exist")
+	errRevokedToken = errors.New("access token revoked")
+	errInvalidKey   = errors.New("invalid api key")
+	errKeyNotUsable = errors.New("api keys can't be used for the route")
+	errMissingScope = errors.New("api key is missing the scope of the route")
+)
+
+// validate the request's access token or api key and load its user
+func (a *Authenticator) authenticate(r *http.Request) (Principal, error) {
+	if key, err := GetUserAPIKey(r.Header); err == nil {
+		return a.authenticateAPIKey(r, key)
+	}
+	// retrieve the jwt from request header
+	token, err := GetBearerToken(r.Header)
+	if err != nil {
+		return Principal{}, errMissingToken
+	}
+	// check if retrieved jwt is valid, get back user ID as subject value
+	claims, err := ValidateJWT(token, a.keys, a.denylist)
+	if errors.Is(err, ErrDenylistIncomplete) {
+		// the session may be one the full denylist couldn't take, it has to be
+		// looked up instead
+		err = a.checkSession(claims.SessionID)
+	}
+	if errors.Is(err, ErrTokenRevoked) {
+		return Principal{}, errRevokedToken
+	}
+	if err != nil {
+		return Principal{}, errInvalidToken
+	}
+	// convert user ID to int
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return Principal{}, errInvalidToken
+	}
+	// the user may have been deleted since the token was issued
+	user, err := a.store.GetUser(userID)
+	if errors.Is(err, database.ErrNotExist) {
+		return Principal{}, errUnknownUser
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+	// tokens issued before the user logged out everywhere or changed password
+	if claims.IssuedAt == nil || claims.IssuedAt.Time.Before(user.TokensValidAfter.Truncate(time.Microsecond)) {
+		return Principal{}, errRevokedToken
+	}
+	return Principal{User: user, Claims: claims}, nil
+}
+
+// ErrTokenRevoked if the session was revoked or is gone from the store
+func (a *Authenticator) checkSession(sessionID int) error {
+	session, err := a.store.GetSession(sessionID)
+	if errors.Is(err, database.ErrNotExist) {
+		return ErrTokenRevoked
+	}
+	if err != nil {
+		return err
+	}
+	if !session.RevokedAt.IsZero() {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// validate an api key, it only works on routes that allow one of its scopes
+func (a *Authenticator) authenticateAPIKey(r *http.Request, key string) (Principal, error) {
+	keyHash := HashAPIKey(key)
+	apiKey, err := a.store.GetAPIKeyByHash(keyHash)
+	if errors.Is(err, database.ErrNotExist) {
+		return Principal{}, errInvalidKey
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+	scope, ok := r.Context().Value(apiKeyScopeKey).(database.Scope)
+	if !ok {
+		return Principal{}, errKeyNotUsable
+	}
+	if !apiKey.HasScope(scope) {
+		return Principal{}, errMissingScope
+	}
+	user, err := a.store.GetUser(apiKey.UserID)
+	if errors.Is(err, database.ErrNotExist) {
+		return Principal{}, errUnknownUser
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+	// last use is shown to the user, it doesn't have to be exact
+	now := time.Now().UTC()
+	if now.Sub(apiKey.LastUsedAt) > apiKeyTouchInterval {
+		err = a.store.TouchAPIKey(keyHash, now)
+		if err != nil {
+			log.Printf("Couldn't record use of api key %d: %s", apiKey.ID, err)
+		}
+	}
+	return Principal{User: user, APIKey: &apiKey}, nil
+}
+
+// Required - rejects requests without a valid access token with 401
+func (a *Authenticator) Required(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := a.authenticate(r)
+		if err != nil {
+			switch {
+			case errors.Is(err, errMissingToken):
+				a.respond(w, http.StatusUnauthorized, "Couldn't find JWT")
+			case errors.Is(err, errInvalidToken):
+				a.respond(w, http.StatusUnauthorized, "Couldn't validate JWT")
+			case errors.Is(err, errUnknownUser):
+				a.respond(w, http.StatusUnauthorized, "Couldn't get user")
+			case errors.Is(err, errRevokedToken):
+				a.respond(w, http.StatusUnauthorized, "Token has been revoked")
+			case errors.Is(err, errInvalidKey):
+				a.respond(w, http.StatusUnauthorized, "Couldn't validate API key")
+			case errors.Is(err, errKeyNotUsable):
+				a.respond(w, http.StatusForbidden, "API keys can't be used here")
+			case errors.Is(err, errMissingScope):
+				scope, _ := r.Context().Value(apiKeyScopeKey).(database.Scope)
+				a.respond(w, http.StatusForbidden, fmt.Sprintf("API key is missing the %s scope", scope))
+			default:
+				a.respond(w, http.StatusInternalServerError, "Couldn't authenticate request")
+			}
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// Optional - adds the principal when the request has a valid access token and
+// lets it through anonymously otherwise, for public routes that show more to
+// a logged in user
+func (a *Authenticator) Optional(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := a.authenticate(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// AllowAPIKeys - lets api keys with the scope authenticate on the route, goes
+// before Required or Optional. Other routes only accept access tokens, so a
+// key can't be used to manage the account it belongs to
+func (a *Authenticator) AllowAPIKeys(scope database.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyScopeKey, scope)))
+		})
+	}
+}
+
+// RequireRole - only lets authenticated users with at least one of the roles
+// through, goes after Required
+func (a *Authenticator) RequireRole(roles ...database.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := UserFromContext(r.Context())
+			if !ok {
+				a.respond(w, http.StatusUnauthorized, "Couldn't find JWT")
+				return
+			}
+			if !user.HasRole(roles...) {
+				a.respond(w, http.StatusForbidden, "You don't have access to this resource")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireVerifiedEmail - only lets authenticated users who verified their
+// email through, goes after Required
+func (a *Authenticator) RequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			a.respond(w, http.StatusUnauthorized, "Couldn't find JWT")
+			return
+		}
+		if !user.EmailVerified {
+			a.respond(w, http.StatusForbidden, "Verify your email address first")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
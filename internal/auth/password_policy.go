@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// bcrypt ignores everything past the first 72 bytes, longer passwords are
+// refused instead of silently cut short
+const maxPasswordBytes = 72
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// PasswordRule - names of the rules a password can break
+type PasswordRule string
+
+const (
+	RuleMinLength PasswordRule = "min_length"
+	RuleMaxBytes  PasswordRule = "max_bytes"
+	RuleCommon    PasswordRule = "common"
+	RuleBreached  PasswordRule = "breached"
+)
+
+// PasswordViolation - one rule a password broke
+type PasswordViolation struct {
+	Rule    PasswordRule `json:"rule"`
+	Message string       `json:"message"`
+}
+
+// PasswordPolicyError - every rule a password broke
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (err *PasswordPolicyError) Error() string {
+	messages := make([]string, 0, len(err.Violations))
+	for _, violation := range err.Violations {
+		messages = append(messages, violation.Message)
+	}
+	return "password rejected: " + strings.Join(messages, ", ")
+}
+
+// BreachChecker - finds out whether a password is known from a data breach
+type BreachChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+// PasswordPolicy - rules new passwords have to follow
+type PasswordPolicy struct {
+	// in characters, not bytes
+	MinLength int
+	// lowercased passwords that are refused because they are guessed first
+	banned map[string]bool
+	// nil to skip the check
+	breaches BreachChecker
+}
+
+// NewPasswordPolicy - policy with the bundled list of common passwords
+// banned, breaches may be nil
+func NewPasswordPolicy(minLength int, breaches BreachChecker) *PasswordPolicy {
+	policy := &PasswordPolicy{
+		MinLength: minLength,
+		banned:    map[string]bool{},
+		breaches:  breaches,
+	}
+	policy.addBanned(commonPasswords)
+	return policy
+}
+
+// BanPasswordsFromFile - bans every password in the file as well, one per
+// line, lines starting with "#" are skipped
+func (policy *PasswordPolicy) BanPasswordsFromFile(path string) error {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	policy.addBanned(string(dat))
+	return nil
+}
+
+func (policy *PasswordPolicy) addBanned(list string) {
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		policy.banned[strings.ToLower(line)] = true
+	}
+}
+
+// Validate - checks the password against every rule, returns a
+// *PasswordPolicyError listing each one it broke
+func (policy *PasswordPolicy) Validate(password string) error {
+	violations := []PasswordViolation{}
+	if utf8.RuneCountInString(password) < policy.MinLength {
+		violations = append(violations, PasswordViolation{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("must be at least %d characters long", policy.MinLength),
+		})
+	}
+	if len(password) > maxPasswordBytes {
+		violations = append(violations, PasswordViolation{
+			Rule:    RuleMaxBytes,
+			Message: fmt.Sprintf("must be at most %d bytes long", maxPasswordBytes),
+		})
+	}
+	if policy.banned[strings.ToLower(password)] {
+		violations = append(violations, PasswordViolation{
+			Rule:    RuleCommon,
+			Message: "is too common",
+		})
+	}
+	if policy.breaches != nil && password != "" {
+		breached, err := policy.breaches.IsBreached(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			violations = append(violations, PasswordViolation{
+				Rule:    RuleBreached,
+				Message: "appears in a known data breach",
+			})
+		}
+	}
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package auth
+
+import "time"
+
+type timedKey struct {
+	key string
+	at  time.Time
+}
+
+// min heap of keys by time, implements heap.Interface. Users keep the current
+// time of each key in a map and skip items that no longer match it
+type timeHeap []timedKey
+
+func (h timeHeap) Len() int           { return len(h) }
+func (h timeHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h timeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *timeHeap) Push(x interface{}) {
+	*h = append(*h, x.(timedKey))
+}
+
+func (h *timeHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 settings every authenticator app supports
+const (
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+	// codes of the steps next to the current one are accepted for clock drift
+	totpSkew = 1
+	// 160 bits, the size of a sha1 hmac key
+	totpSecretBytes = 20
+)
+
+// 80 bits each, hashes of them are stored without a salt so guessing one
+// offline has to stay out of reach
+const (
+	recoveryCodeBytes = 10
+	recoveryCodeGroup = 5
+)
+
+var ErrInvalidTOTPCode = errors.New("invalid totp code")
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret - random base32 secret to share with an authenticator app
+func NewTOTPSecret() (string, error) {
+	dat := make([]byte, totpSecretBytes)
+	_, err := rand.Read(dat)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(dat), nil
+}
+
+// TOTPURI - otpauth uri for the secret, authenticator apps read it from a QR
+// code and list the account under issuer
+func TOTPURI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprintf("%d", totpDigits))
+	query.Set("period", fmt.Sprintf("%d", int(totpPeriod/time.Second)))
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+	return uri.String()
+}
+
+// ValidateTOTP - checks a code from an authenticator app against the secret,
+// returns the time step it was made for so it can be refused if used again
+func ValidateTOTP(secret, code string, now time.Time) (int64, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, err
+	}
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, ErrInvalidTOTPCode
+	}
+	current := now.Unix() / int64(totpPeriod/time.Second)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, nil
+		}
+	}
+	return 0, ErrInvalidTOTPCode
+}
+
+// the code for a time step, RFC 4226's dynamic truncation of the hmac
+func totpCode(key []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%modulo)
+}
+
+// MakeRecoveryCodes - n random single use codes for logging in without the
+// authenticator, shown to the user once and only stored hashed
+func MakeRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		code, err := randomHex(recoveryCodeBytes)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, groupCode(code))
+	}
+	return codes, nil
+}
+
+// split into dash separated groups so the code is easier to copy down
+func groupCode(code string) string {
+	groups := make([]string, 0, len(code)/recoveryCodeGroup+1)
+	for len(code) > recoveryCodeGroup {
+		groups = append(groups, code[:recoveryCodeGroup])
+		code = code[recoveryCodeGroup:]
+	}
+	groups = append(groups, code)
+	return strings.Join(groups, "-")
+}
+
+// HashRecoveryCode - hash recovery codes are stored by, ignoring case,
+// spaces and dashes people add when typing them in
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return hashOpaqueToken(code)
+}
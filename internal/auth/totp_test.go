@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"encoding/base32"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the SHA1 seed from RFC 6238 appendix B
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).
+	EncodeToString([]byte("12345678901234567890"))
+
+func TestValidateTOTPRFC6238(t *testing.T) {
+	// the RFC lists 8 digit codes, 6 digit codes are their last 6 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		now := time.Unix(tt.unix, 0)
+		step, err := ValidateTOTP(rfc6238Secret, tt.code, now)
+		if err != nil {
+			t.Errorf("ValidateTOTP at %d: %v", tt.unix, err)
+			continue
+		}
+		if want := tt.unix / 30; step != want {
+			t.Errorf("ValidateTOTP at %d: step %d, want %d", tt.unix, step, want)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	// 287082 is the code for step 1
+	tests := []struct {
+		unix int64
+		ok   bool
+	}{
+		{0, true},
+		{59, true},
+		{89, true},
+		{90, false},
+	}
+	for _, tt := range tests {
+		_, err := ValidateTOTP(rfc6238Secret, "287082", time.Unix(tt.unix, 0))
+		if tt.ok && err != nil {
+			t.Errorf("ValidateTOTP at %d: %v", tt.unix, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalidTOTPCode) {
+			t.Errorf("ValidateTOTP at %d: got %v, want ErrInvalidTOTPCode", tt.unix, err)
+		}
+	}
+}
+
+func TestValidateTOTPRejects(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "287083", "abcdef"} {
+		_, err := ValidateTOTP(rfc6238Secret, code, now)
+		if !errors.Is(err, ErrInvalidTOTPCode) {
+			t.Errorf("ValidateTOTP(%q): got %v, want ErrInvalidTOTPCode", code, err)
+		}
+	}
+	// lowercase secrets from copy and paste are fine
+	_, err := ValidateTOTP(strings.ToLower(rfc6238Secret), "287082", now)
+	if err != nil {
+		t.Errorf("ValidateTOTP with lowercase secret: %v", err)
+	}
+}
+
+func TestMakeRecoveryCodes(t *testing.T) {
+	codes, err := MakeRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("got %d codes, want 10", len(codes))
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		digits := strings.ReplaceAll(code, "-", "")
+		// hex digits carry 4 bits each
+		if len(digits)*4 < 80 {
+			t.Errorf("code %q has less than 80 bits", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q made twice", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	want := HashRecoveryCode("0123a-4567b-89cde-f0123")
+	for _, typed := range []string{"0123A-4567B-89CDE-F0123", "0123a4567b89cdef0123", " 0123a 4567b 89cde f0123 "} {
+		if got := HashRecoveryCode(typed); got != want {
+			t.Errorf("HashRecoveryCode(%q) doesn't match the code as shown", typed)
+		}
+	}
+	if HashRecoveryCode("0123a-4567b-89cde-f0124") == want {
+		t.Error("different codes hash the same")
+	}
+}
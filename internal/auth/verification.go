@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrInvalidVerificationToken = errors.New("invalid email verification token")
+
+// MakeEmailVerificationToken - creates a token proving whoever holds it
+// received mail sent to email, for the user with userID
+func MakeEmailVerificationToken(userID int, email string, keys *Keys, expiresIn time.Duration) (string, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	return keys.sign(Claims{
+		Email: email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeEmailVerification),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Subject:   fmt.Sprintf("%d", userID),
+			ID:        tokenID,
+		},
+	})
+}
+
+// ParseEmailVerificationToken - checks the token, returns the user id and the
+// email it was sent to
+func ParseEmailVerificationToken(tokenString string, keys *Keys) (int, string, error) {
+	claims, err := ParseJWT(tokenString, keys, TokenTypeEmailVerification)
+	if err != nil {
+		return 0, "", fmt.Errorf("%w: %w", ErrInvalidVerificationToken, err)
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || claims.Email == "" {
+		return 0, "", ErrInvalidVerificationToken
+	}
+	return userID, claims.Email, nil
+}
@@ -0,0 +1,163 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// Scope - what an api key may be used for, keys can't do anything else
+type Scope string
+
+const (
+	// ScopeChirpsRead - reading chirps as the key's user, including their own
+	// pending ones
+	ScopeChirpsRead Scope = "chirps:read"
+	// ScopeChirpsWrite - posting and deleting the user's chirps
+	ScopeChirpsWrite Scope = "chirps:write"
+)
+
+var ErrInvalidScope = errors.New("invalid scope")
+
+// ParseScope - validates a scope from outside the db
+func ParseScope(s string) (Scope, error) {
+	switch scope := Scope(s); scope {
+	case ScopeChirpsRead, ScopeChirpsWrite:
+		return scope, nil
+	default:
+		return "", ErrInvalidScope
+	}
+}
+
+// APIKey - a long lived credential a user made for a bot or integration,
+// only its hash is stored
+type APIKey struct {
+	ID     int `json:"id"`
+	UserID int `json:"user_id"`
+	// chosen by the user to tell their keys apart
+	Name string `json:"name"`
+	// start of the key, kept in the clear so users can recognize their keys
+	Prefix string  `json:"prefix"`
+	Hash   string  `json:"hash"`
+	Scopes []Scope `json:"scopes"`
+	// zero until the key is used
+	LastUsedAt time.Time `json:"last_used_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// HasScope - whether the key may be used for scope
+func (key APIKey) HasScope(scope Scope) bool {
+	for _, have := range key.Scopes {
+		if have == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIKey - stores a new api key, the id and CreatedAt are filled in,
+// ErrNotExist if its user doesn't exist
+func (db *DB) CreateAPIKey(key APIKey) (APIKey, error) {
+	err := db.Update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[key.UserID]; !ok {
+			return ErrNotExist
+		}
+		key.ID = dbStructure.nextID(seqAPIKeys)
+		key.CreatedAt = time.Now().UTC()
+		key.LastUsedAt = time.Time{}
+		dbStructure.APIKeys[key.Hash] = key
+		return nil
+	})
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return key, nil
+}
+
+// GetAPIKeyByHash - the api key with the hash, ErrNotExist if it was revoked
+// or never existed
+func (db *DB) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	key := APIKey{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		var ok bool
+		key, ok = dbStructure.APIKeys[keyHash]
+		if !ok {
+			return ErrNotExist
+		}
+		return nil
+	})
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return key, nil
+}
+
+// ListAPIKeys - the user's api keys, newest first
+func (db *DB) ListAPIKeys(userID int) ([]APIKey, error) {
+	keys := []APIKey{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		for _, key := range dbStructure.APIKeys {
+			if key.UserID == userID {
+				keys = append(keys, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID > keys[j].ID
+	})
+
+	return keys, nil
+}
+
+// DeleteAPIKey - revokes the user's api key, ErrNotExist if the user has no
+// key with the id
+func (db *DB) DeleteAPIKey(userID, id int) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		for hash, key := range dbStructure.APIKeys {
+			if key.ID == id && key.UserID == userID {
+				delete(dbStructure.APIKeys, hash)
+				return nil
+			}
+		}
+		return ErrNotExist
+	})
+}
+
+// RevokeUserAPIKeys - revokes every api key of the user, returns how many
+// there were
+func (db *DB) RevokeUserAPIKeys(userID int) (int, error) {
+	revoked := 0
+	err := db.Update(func(dbStructure *DBStructure) error {
+		for hash, key := range dbStructure.APIKeys {
+			if key.UserID == userID {
+				delete(dbStructure.APIKeys, hash)
+				revoked++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return revoked, nil
+}
+
+// TouchAPIKey - records when the api key was last used
+func (db *DB) TouchAPIKey(keyHash string, usedAt time.Time) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		key, ok := dbStructure.APIKeys[keyHash]
+		if !ok {
+			return ErrNotExist
+		}
+		key.LastUsedAt = usedAt.UTC()
+		dbStructure.APIKeys[keyHash] = key
+		return nil
+	})
+}
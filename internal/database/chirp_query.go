@@ -0,0 +1,100 @@
+package database
+
+import (
+	"sort"
+	"time"
+)
+
+// ChirpSort - field chirps are ordered by, ties are always broken by id
+type ChirpSort string
+
+const (
+	ChirpSortID        ChirpSort = "id"
+	ChirpSortCreatedAt ChirpSort = "created_at"
+)
+
+// ChirpQuery - filters and keyset pagination for ListChirps
+type ChirpQuery struct {
+	// only chirps by this author, 0 for all authors
+	AuthorID int
+	// only chirps with one of these statuses, empty for every status
+	Statuses []ChirpStatus
+	// with Statuses set, also include this user's own pending chirps, 0 for none
+	ViewerID int
+	// only chirps created at or after Since, zero for no lower bound
+	Since time.Time
+	// only chirps created before Until, zero for no upper bound
+	Until time.Time
+	// field to order by, defaults to id
+	SortBy ChirpSort
+	// newest first instead of oldest first
+	Desc bool
+	// only chirps after this position in the sort order, AfterID 0 to start
+	// from the beginning, AfterCreatedAt is only used when sorting by created_at
+	AfterID        int
+	AfterCreatedAt time.Time
+	// maximum number of chirps returned, 0 for no limit
+	Limit int
+}
+
+// less - whether a comes before b in the query's sort order
+func (query ChirpQuery) less(a, b Chirp) bool {
+	if query.SortBy == ChirpSortCreatedAt && !a.CreatedAt.Equal(b.CreatedAt) {
+		if query.Desc {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	if query.Desc {
+		return a.ID > b.ID
+	}
+	return a.ID < b.ID
+}
+
+// matches - whether chirp passes the filters and comes after the cursor
+func (query ChirpQuery) matches(chirp Chirp) bool {
+	if query.AuthorID != 0 && chirp.AuthorID != query.AuthorID {
+		return false
+	}
+	if !chirp.VisibleTo(query.Statuses, query.ViewerID) {
+		return false
+	}
+	if !query.Since.IsZero() && chirp.CreatedAt.Before(query.Since) {
+		return false
+	}
+	if !query.Until.IsZero() && !chirp.CreatedAt.Before(query.Until) {
+		return false
+	}
+	if query.AfterID != 0 {
+		cursor := Chirp{ID: query.AfterID, CreatedAt: query.AfterCreatedAt}
+		if !query.less(cursor, chirp) {
+			return false
+		}
+	}
+	return true
+}
+
+// ListChirps - returns one page of chirps in the query's sort order
+func (db *DB) ListChirps(query ChirpQuery) ([]Chirp, error) {
+	chirps := []Chirp{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		for _, chirp := range dbStructure.Chirps {
+			if query.matches(chirp) {
+				chirps = append(chirps, chirp)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// ids are unique so the order is stable across pages
+	sort.Slice(chirps, func(i, j int) bool {
+		return query.less(chirps[i], chirps[j])
+	})
+	if query.Limit > 0 && len(chirps) > query.Limit {
+		chirps = chirps[:query.Limit]
+	}
+
+	return chirps, nil
+}
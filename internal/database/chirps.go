@@ -1,26 +1,86 @@
 package database
 
+import (
+	"errors"
+	"time"
+)
+
+// ChirpStatus - moderation state of a chirp
+type ChirpStatus string
+
+const (
+	// ChirpStatusPending - flagged by moderation, hidden until reviewed
+	ChirpStatusPending ChirpStatus = "pending"
+	// ChirpStatusApproved - visible to everyone
+	ChirpStatusApproved ChirpStatus = "approved"
+	// ChirpStatusRejected - hidden after review
+	ChirpStatusRejected ChirpStatus = "rejected"
+)
+
+// ParseChirpStatus - validates a status from outside the db
+func ParseChirpStatus(s string) (ChirpStatus, error) {
+	switch status := ChirpStatus(s); status {
+	case ChirpStatusPending, ChirpStatusApproved, ChirpStatusRejected:
+		return status, nil
+	default:
+		return "", ErrInvalidStatus
+	}
+}
+
+var ErrInvalidStatus = errors.New("invalid chirp status")
+
 type Chirp struct {
 	AuthorID int    `json:"author_id"`
 	Body     string `json:"body"`
 	ID       int    `json:"id"`
+	// opaque id safe to show publicly, empty unless the db issues public ids
+	PublicID  string    `json:"public_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// moderation state, only approved chirps are public
+	Status ChirpStatus `json:"status"`
+	// names of the moderation rules that flagged the chirp for review
+	Flags []string `json:"flags,omitempty"`
+	// reason given by the moderator who approved or rejected the chirp
+	ReviewReason string `json:"review_reason,omitempty"`
 }
 
-func (db *DB) CreateChirp(body string, author_id int) (Chirp, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return Chirp{}, err
+// VisibleTo - whether the chirp passes the status filter, with a viewer also
+// seeing their own pending chirps, an empty filter allows every status
+func (chirp Chirp) VisibleTo(statuses []ChirpStatus, viewerID int) bool {
+	if len(statuses) == 0 {
+		return true
 	}
-
-	id := len(dbStructure.Chirps) + 1
-	chirp := Chirp{
-		ID:       id,
-		Body:     body,
-		AuthorID: author_id,
+	for _, status := range statuses {
+		if chirp.Status == status {
+			return true
+		}
 	}
-	dbStructure.Chirps[id] = chirp
+	return viewerID != 0 && chirp.AuthorID == viewerID && chirp.Status == ChirpStatusPending
+}
 
-	err = db.writeDB(dbStructure)
+func (db *DB) CreateChirp(body string, authorID int, status ChirpStatus, flags []string) (Chirp, error) {
+	publicID, err := newPublicID(db.idFormat)
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp := Chirp{}
+	err = db.Update(func(dbStructure *DBStructure) error {
+		id := dbStructure.nextID(seqChirps)
+		now := time.Now().UTC()
+		chirp = Chirp{
+			ID:        id,
+			PublicID:  publicID,
+			Body:      body,
+			AuthorID:  authorID,
+			CreatedAt: now,
+			UpdatedAt: now,
+			Status:    status,
+			Flags:     flags,
+		}
+		dbStructure.Chirps[id] = chirp
+		return nil
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
@@ -29,44 +89,97 @@ func (db *DB) CreateChirp(body string, author_id int) (Chirp, error) {
 }
 
 func (db *DB) GetChirps() ([]Chirp, error) {
-	dbStructure, err := db.loadDB()
+	chirps := []Chirp{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		chirps = make([]Chirp, 0, len(dbStructure.Chirps))
+		for _, chirp := range dbStructure.Chirps {
+			chirps = append(chirps, chirp)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	chirps := make([]Chirp, 0, len(dbStructure.Chirps))
-	for _, chirp := range dbStructure.Chirps {
-		chirps = append(chirps, chirp)
-	}
-
 	return chirps, nil
 }
 
 func (db *DB) GetChirp(id int) (Chirp, error) {
-	dbStructure, err := db.loadDB()
+	chirp := Chirp{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		var ok bool
+		chirp, ok = dbStructure.Chirps[id]
+		if !ok {
+			return ErrNotExist
+		}
+		return nil
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
 
-	chirp, ok := dbStructure.Chirps[id]
-	if !ok {
-		return Chirp{}, ErrNotExist
+	return chirp, nil
+}
+
+func (db *DB) GetChirpByPublicID(publicID string) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		for _, c := range dbStructure.Chirps {
+			if publicID != "" && c.PublicID == publicID {
+				chirp = c
+				return nil
+			}
+		}
+		return ErrNotExist
+	})
+	if err != nil {
+		return Chirp{}, err
 	}
 
 	return chirp, nil
 }
 
 func (db *DB) DeleteChirp(id int) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
+	return db.Update(func(dbStructure *DBStructure) error {
+		delete(dbStructure.Chirps, id)
+		return nil
+	})
+}
 
-	delete(dbStructure.Chirps, id)
-	err = db.writeDB(dbStructure)
+// SetChirpStatus - records a moderation decision on the chirp
+func (db *DB) SetChirpStatus(id int, status ChirpStatus, reason string) (Chirp, error) {
+	chirp := Chirp{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		var ok bool
+		chirp, ok = dbStructure.Chirps[id]
+		if !ok {
+			return ErrNotExist
+		}
+		chirp.Status = status
+		chirp.ReviewReason = reason
+		chirp.UpdatedAt = time.Now().UTC()
+		dbStructure.Chirps[id] = chirp
+		return nil
+	})
 	if err != nil {
-		return err
+		return Chirp{}, err
 	}
 
-	return nil
+	return chirp, nil
+}
+
+// SearchChirps - full text search over chirp bodies, best match first
+func (db *DB) SearchChirps(query SearchQuery) ([]ChirpMatch, error) {
+	return searchChirps(db.search, query, func(ids []int) (map[int]Chirp, error) {
+		chirps := make(map[int]Chirp, len(ids))
+		err := db.View(func(dbStructure *DBStructure) error {
+			for _, id := range ids {
+				if chirp, ok := dbStructure.Chirps[id]; ok {
+					chirps[id] = chirp
+				}
+			}
+			return nil
+		})
+		return chirps, err
+	})
 }
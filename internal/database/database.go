@@ -12,84 +12,201 @@ var ErrNotExist = errors.New("resource does not exist")
 type DB struct {
 	path string
 	mu   *sync.RWMutex
+	// format of the public ids given to new chirps and users
+	idFormat IDFormat
+	// in memory copy of the db file, only read or replaced while holding mu
+	data DBStructure
+	// full text index over chirp bodies, kept in sync by CreateChirp and DeleteChirp
+	search *searchIndex
+	// held until Close or exit, so no other process writes the file
+	lock *fileLock
 }
 
 type DBStructure struct {
+	// version of the layout below, see migrations.go
+	SchemaVersion int `json:"schema_version"`
 	// map of chirps for storing new chirps and return list of chirps
 	Chirps map[int]Chirp `json:"chirps"`
 	// map of users for user related functions
 	Users map[int]User `json:"users"`
-	// map of revocations for storing revoked refresh tokens
-	Revocations map[string]Revocation `json:"revocations"`
+	// logins of users, kept alive by exchanging refresh tokens
+	Sessions map[int]Session `json:"sessions"`
+	// refresh tokens of the sessions by hash, rotated ones are kept for a while to detect reuse
+	RefreshTokens map[string]RefreshToken `json:"refresh_tokens"`
+	// password reset tokens by hash, used ones are kept until they expire
+	PasswordResets map[string]PasswordReset `json:"password_resets"`
+	// failed logins in a row by account or address, for locking out guessers
+	LoginAttempts map[string]LoginAttempts `json:"login_attempts"`
+	// api keys of users by hash
+	APIKeys map[string]APIKey `json:"api_keys"`
+	// last id handed out per table, so ids are never reused after a delete
+	Sequences map[string]int `json:"sequences"`
 }
 
 func NewDB(path string) (*DB, error) {
 	db := &DB{
-		path: path,
-		mu:   &sync.RWMutex{},
+		path:   path,
+		mu:     &sync.RWMutex{},
+		search: newSearchIndex(),
 	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// the file is cached in memory, another process writing it would have its
+	// changes overwritten by the next write from this one
+	lock, err := acquireLock(lockPath(path))
+	if err != nil {
+		return db, err
+	}
+	db.lock = lock
+	err = db.load()
+	if err != nil {
+		db.lock.release()
+		return db, err
+	}
+	return db, nil
+}
+
+func lockPath(path string) string {
+	return path + ".lock"
+}
+
+// read the file and the operation log into the cache, caller must hold mu
+func (db *DB) load() error {
 	err := db.ensureDB()
-	return db, err
+	if err != nil {
+		return err
+	}
+	// bring the snapshot up to date with operations logged before a crash
+	err = db.recover()
+	if err != nil {
+		return err
+	}
+	for _, chirp := range db.data.Chirps {
+		db.search.add(chirp)
+	}
+	return nil
+}
+
+// Close - lets other processes open the db file
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.lock.release()
 }
 
+func newDBStructure() DBStructure {
+	return DBStructure{
+		SchemaVersion:  CurrentSchemaVersion(),
+		Chirps:         map[int]Chirp{},
+		Users:          map[int]User{},
+		Sessions:       map[int]Session{},
+		RefreshTokens:  map[string]RefreshToken{},
+		PasswordResets: map[string]PasswordReset{},
+		LoginAttempts:  map[string]LoginAttempts{},
+		APIKeys:        map[string]APIKey{},
+		Sequences:      map[string]int{},
+	}
+}
+
+// write an empty db file and use it as the cache, caller must hold mu
 func (db *DB) createDB() error {
-	dbStructure := DBStructure{
-		Chirps:      map[int]Chirp{},
-		Users:       map[int]User{},
-		Revocations: map[string]Revocation{},
+	dbStructure := newDBStructure()
+	err := db.writeDB(dbStructure)
+	if err != nil {
+		return err
 	}
-	return db.writeDB(dbStructure)
+	db.data = dbStructure
+	return nil
 }
 
+// load the db file into the cache, creating it first if missing and upgrading
+// it to the current schema if older, caller must hold mu
 func (db *DB) ensureDB() error {
-	_, err := os.ReadFile(db.path)
+	dbStructure, plan, err := db.loadDB()
 	if errors.Is(err, os.ErrNotExist) {
 		return db.createDB()
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	if plan.NeedsMigration() {
+		// keep the old file around before replacing it with the upgraded one
+		_, err = migrateFile(db.path, MigrateOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	db.data = dbStructure
+	return nil
 }
 
 func (db *DB) ResetDB() error {
-	err := os.Remove(db.path)
-	if errors.Is(err, os.ErrNotExist) {
-		return nil
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// drop the operation log first so it can't be replayed onto the new db
+	err := os.Remove(db.logPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
 	}
-	return db.ensureDB()
+	err = os.Remove(db.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	db.search.reset()
+	return db.createDB()
 }
 
-// load database info into memory, into local struct
-func (db *DB) loadDB() (DBStructure, error) {
-	// need to locak mux since accessing db
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-
+// read the db file from disk into a local struct, migrating it in memory if
+// it was written with an older schema, caller must hold mu
+func (db *DB) loadDB() (DBStructure, MigrationPlan, error) {
 	dbStructure := DBStructure{}
 	dat, err := os.ReadFile(db.path)
-	if errors.Is(err, os.ErrNotExist) {
-		return dbStructure, err
+	if err != nil {
+		return dbStructure, MigrationPlan{}, err
+	}
+	dat, plan, err := migrateSnapshot(dat)
+	if err != nil {
+		return dbStructure, plan, err
 	}
 	err = json.Unmarshal(dat, &dbStructure)
 	if err != nil {
-		return dbStructure, err
+		return dbStructure, plan, err
 	}
+	// make sure a table missing from the file is still usable
+	dbStructure.ensureMaps()
 
-	return dbStructure, nil
+	return dbStructure, plan, nil
 }
 
 // write new structure to db, after converting local struct to json
-func (db *DB) writeDB(dbStructure DBStructure) error {
-	// need to locak mux since accessing db
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
+// entries describe the change and are logged before the snapshot is replaced
+// caller must hold mu for writing
+func (db *DB) writeDB(dbStructure DBStructure, entries ...logEntry) error {
 	dat, err := json.Marshal(dbStructure)
 	if err != nil {
 		return err
 	}
-
-	err = os.WriteFile(db.path, dat, 0600)
+	size, err := db.logSize()
 	if err != nil {
 		return err
 	}
-	return nil
+	// log first, if we crash before the rename the change is replayed on startup
+	err = db.appendLog(entries)
+	if err != nil {
+		return db.rollbackLog(size, err)
+	}
+	err = writeFileAtomic(db.path, dat, 0600)
+	if errors.Is(err, errRenameNotSynced) {
+		// the new snapshot is already what readers and a restart see, and the
+		// fsynced log still makes the change durable, so it counts as committed
+		// and the log is kept until a later write syncs
+		return nil
+	}
+	if err != nil {
+		return db.rollbackLog(size, err)
+	}
+	// snapshot now holds every logged change
+	return db.truncateLog()
 }
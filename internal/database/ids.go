@@ -0,0 +1,70 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// names of the per table id sequences
+const (
+	seqChirps   = "chirps"
+	seqUsers    = "users"
+	seqSessions = "sessions"
+	seqAPIKeys  = "api_keys"
+)
+
+// IDFormat - format of the opaque public ids given to chirps and users
+type IDFormat string
+
+const (
+	// IDFormatNone - records only get the sequential integer id
+	IDFormatNone IDFormat = ""
+	// IDFormatUUIDv7 - records also get a time ordered UUIDv7 public id
+	IDFormatUUIDv7 IDFormat = "uuidv7"
+)
+
+// hand out the next id of the table, ids keep increasing even after deletes
+func (dbStructure *DBStructure) nextID(table string) int {
+	dbStructure.Sequences[table]++
+	return dbStructure.Sequences[table]
+}
+
+// new public id in the given format, empty when public ids are disabled
+func newPublicID(format IDFormat) (string, error) {
+	switch format {
+	case IDFormatUUIDv7:
+		return newUUIDv7()
+	default:
+		return "", nil
+	}
+}
+
+// UUIDv7 from RFC 9562: 48 bit unix milliseconds followed by random bits,
+// so ids sort by creation time but can't be guessed from one another
+func newUUIDv7() (string, error) {
+	var u [16]byte
+	_, err := rand.Read(u[:])
+	if err != nil {
+		return "", err
+	}
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(time.Now().UnixMilli()))
+	copy(u[0:6], ts[2:8])
+	// version 7 in the high nibble of byte 6, variant 0b10 in byte 8
+	u[6] = (u[6] & 0x0f) | 0x70
+	u[8] = (u[8] & 0x3f) | 0x80
+
+	buf := make([]byte, 36)
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf), nil
+}
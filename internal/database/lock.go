@@ -0,0 +1,43 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrLocked - another process has the json database open, it keeps the file
+// cached in memory so writes from a second process would be lost
+var ErrLocked = errors.New("database is in use by another process")
+
+// returned by lockFileExclusive when someone else holds the lock
+var errLockHeld = errors.New("lock held")
+
+// fileLock - exclusive lock on a file next to the db, released when the
+// process exits even if it crashes
+type fileLock struct {
+	file *os.File
+}
+
+// take the lock at path without waiting, ErrLocked if another process holds it
+func acquireLock(path string) (*fileLock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = lockFileExclusive(file)
+	if errors.Is(err, errLockHeld) {
+		file.Close()
+		return nil, fmt.Errorf("%w: %s is locked", ErrLocked, path)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &fileLock{file: file}, nil
+}
+
+// release - closing the file drops the lock
+func (lock *fileLock) release() error {
+	return lock.file.Close()
+}
@@ -0,0 +1,76 @@
+package database
+
+import "time"
+
+// LoginAttempts - failed logins in a row for one key, an account or an address
+type LoginAttempts struct {
+	Key          string    `json:"key"`
+	Failures     int       `json:"failures"`
+	LastFailedAt time.Time `json:"last_failed_at"`
+}
+
+// GetLoginAttempts - failed logins recorded for key, no failures if there are none
+func (db *DB) GetLoginAttempts(key string) (LoginAttempts, error) {
+	attempts := LoginAttempts{Key: key}
+	err := db.View(func(dbStructure *DBStructure) error {
+		if stored, ok := dbStructure.LoginAttempts[key]; ok {
+			attempts = stored
+		}
+		return nil
+	})
+	if err != nil {
+		return LoginAttempts{}, err
+	}
+
+	return attempts, nil
+}
+
+// RecordLoginFailure - counts a failed login for key at the given time, a
+// streak whose last failure is before resetBefore starts over
+func (db *DB) RecordLoginFailure(key string, at, resetBefore time.Time) (LoginAttempts, error) {
+	attempts := LoginAttempts{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		attempts = dbStructure.LoginAttempts[key]
+		if attempts.LastFailedAt.Before(resetBefore) {
+			attempts.Failures = 0
+		}
+		attempts.Key = key
+		attempts.Failures++
+		attempts.LastFailedAt = at.UTC()
+		dbStructure.LoginAttempts[key] = attempts
+		return nil
+	})
+	if err != nil {
+		return LoginAttempts{}, err
+	}
+
+	return attempts, nil
+}
+
+// ClearLoginAttempts - forgets the failed logins of key
+func (db *DB) ClearLoginAttempts(key string) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		delete(dbStructure.LoginAttempts, key)
+		return nil
+	})
+}
+
+// DeleteStaleLoginAttempts - removes streaks whose last failure is before
+// cutoff, returns how many were removed
+func (db *DB) DeleteStaleLoginAttempts(cutoff time.Time) (int, error) {
+	removed := 0
+	err := db.Update(func(dbStructure *DBStructure) error {
+		for key, attempts := range dbStructure.LoginAttempts {
+			if attempts.LastFailedAt.Before(cutoff) {
+				delete(dbStructure.LoginAttempts, key)
+				removed++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,353 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+var ErrSchemaTooNew = errors.New("database schema is newer than this version of chirpy")
+
+// ErrPendingLog - the operation log holds changes written with the old schema,
+// replaying them after migrating would overwrite the migrated records
+var ErrPendingLog = errors.New("database has an operation log that was not replayed")
+
+// migration upgrades a decoded database.json document by one schema version,
+// it works on the raw document so older layouts don't need matching structs
+type migration struct {
+	description string
+	up          func(doc map[string]interface{}) error
+}
+
+// ordered registry of migrations, migrations[i] upgrades version i to i+1
+// only ever append to this list, released migrations must not change
+var migrations = []migration{
+	{
+		description: "add per table id sequences",
+		up:          migrateAddSequences,
+	},
+	{
+		description: "add created_at and updated_at to chirps and users",
+		up:          migrateAddTimestamps,
+	},
+	{
+		description: "add moderation status to chirps",
+		up:          migrateAddChirpStatus,
+	},
+	{
+		description: "add roles to users",
+		up:          migrateAddUserRoles,
+	},
+	{
+		description: "replace refresh token revocations with sessions",
+		up:          migrateAddSessions,
+	},
+	{
+		description: "add failed login attempts",
+		up:          migrateAddLoginAttempts,
+	},
+	{
+		description: "add email verification to users",
+		up:          migrateAddEmailVerified,
+	},
+	{
+		description: "add password reset tokens",
+		up:          migrateAddPasswordResets,
+	},
+	{
+		description: "add api keys",
+		up:          migrateAddAPIKeys,
+	},
+}
+
+// CurrentSchemaVersion - schema version written by this version of chirpy
+func CurrentSchemaVersion() int {
+	return len(migrations)
+}
+
+// MigrationPlan - describes the upgrade from one schema version to another
+type MigrationPlan struct {
+	From  int
+	To    int
+	Steps []string
+	// where the pre-migration file was copied to, empty if no backup was made
+	BackupPath string
+}
+
+// NeedsMigration - true if the plan has any steps to run
+func (plan MigrationPlan) NeedsMigration() bool {
+	return plan.From < plan.To
+}
+
+// MigrateOptions - controls how Migrate upgrades a file
+type MigrateOptions struct {
+	// only report what would be done, without writing anything
+	DryRun bool
+	// skip copying the old file before it is replaced
+	NoBackup bool
+}
+
+// Migrate - upgrades the database.json file at path to the current schema
+func Migrate(path string, opts MigrateOptions) (MigrationPlan, error) {
+	// a running server would overwrite the migrated file from its cache
+	lock, err := acquireLock(lockPath(path))
+	if err != nil {
+		return MigrationPlan{}, err
+	}
+	defer lock.release()
+	return migrateFile(path, opts)
+}
+
+// Migrate without taking the lock, for NewDB which already holds it
+func migrateFile(path string, opts MigrateOptions) (MigrationPlan, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return MigrationPlan{}, err
+	}
+	upgraded, plan, err := migrateSnapshot(dat)
+	if err != nil {
+		return plan, err
+	}
+	if !plan.NeedsMigration() {
+		return plan, nil
+	}
+	// the log is only left behind by a crash, the version that wrote it
+	// replays it when started
+	entries, err := readLogFile(logPath(path))
+	if err != nil {
+		return plan, err
+	}
+	if len(entries) > 0 {
+		return plan, fmt.Errorf("%w: start the chirpy version that wrote %s (schema version %d) once to replay it before migrating", ErrPendingLog, logPath(path), plan.From)
+	}
+	if opts.DryRun {
+		return plan, nil
+	}
+	if !opts.NoBackup {
+		plan.BackupPath, err = backupFile(path, dat, plan.From)
+		if err != nil {
+			return plan, err
+		}
+	}
+	return plan, writeFileAtomic(path, upgraded, 0600)
+}
+
+// run all pending migrations on the raw file content
+func migrateSnapshot(dat []byte) ([]byte, MigrationPlan, error) {
+	doc := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(dat))
+	// keep numbers exact instead of turning them into float64
+	decoder.UseNumber()
+	err := decoder.Decode(&doc)
+	if err != nil {
+		return nil, MigrationPlan{}, err
+	}
+
+	version, err := docSchemaVersion(doc)
+	if err != nil {
+		return nil, MigrationPlan{}, err
+	}
+	plan := MigrationPlan{From: version, To: CurrentSchemaVersion()}
+	if version > plan.To {
+		return nil, plan, fmt.Errorf("%w: file is version %d, supported up to %d", ErrSchemaTooNew, version, plan.To)
+	}
+	if !plan.NeedsMigration() {
+		return dat, plan, nil
+	}
+
+	for v := version; v < plan.To; v++ {
+		m := migrations[v]
+		plan.Steps = append(plan.Steps, fmt.Sprintf("%d -> %d: %s", v, v+1, m.description))
+		err := m.up(doc)
+		if err != nil {
+			return nil, plan, fmt.Errorf("migration %d -> %d: %w", v, v+1, err)
+		}
+		doc["schema_version"] = v + 1
+	}
+
+	upgraded, err := json.Marshal(doc)
+	if err != nil {
+		return nil, plan, err
+	}
+	return upgraded, plan, nil
+}
+
+// files written before versioning have no schema_version and count as 0
+func docSchemaVersion(doc map[string]interface{}) (int, error) {
+	raw, ok := doc["schema_version"]
+	if !ok {
+		return 0, nil
+	}
+	num, ok := raw.(json.Number)
+	if !ok {
+		return 0, errors.New("schema_version is not a number")
+	}
+	version, err := strconv.Atoi(num.String())
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema_version: %w", err)
+	}
+	return version, nil
+}
+
+// copy the old file next to it, e.g. "database.json.v0-20240101T120000Z.bak"
+func backupFile(path string, dat []byte, version int) (string, error) {
+	backupPath := fmt.Sprintf("%s.v%d-%s.bak", path, version, time.Now().UTC().Format("20060102T150405Z"))
+	err := writeFileAtomic(backupPath, dat, 0600)
+	if err != nil {
+		return "", err
+	}
+	return backupPath, nil
+}
+
+// 0 -> 1: start every sequence after the highest id already in its table
+func migrateAddSequences(doc map[string]interface{}) error {
+	sequences, _ := doc["sequences"].(map[string]interface{})
+	if sequences == nil {
+		sequences = map[string]interface{}{}
+	}
+	for _, table := range []string{seqChirps, seqUsers} {
+		highest := 0
+		if seq, ok := sequences[table].(json.Number); ok {
+			n, err := strconv.Atoi(seq.String())
+			if err != nil {
+				return err
+			}
+			highest = n
+		}
+		records, _ := doc[table].(map[string]interface{})
+		for key := range records {
+			id, err := strconv.Atoi(key)
+			if err != nil {
+				return fmt.Errorf("invalid %s id %q", table, key)
+			}
+			if id > highest {
+				highest = id
+			}
+		}
+		sequences[table] = highest
+	}
+	doc["sequences"] = sequences
+	return nil
+}
+
+// 1 -> 2: records from before timestamps existed get the time of the upgrade
+func migrateAddTimestamps(doc map[string]interface{}) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	for _, table := range []string{"chirps", "users"} {
+		records, _ := doc[table].(map[string]interface{})
+		for key, raw := range records {
+			record, ok := raw.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("invalid %s record %q", table, key)
+			}
+			if _, ok := record["created_at"]; !ok {
+				record["created_at"] = now
+			}
+			if _, ok := record["updated_at"]; !ok {
+				record["updated_at"] = record["created_at"]
+			}
+		}
+	}
+	return nil
+}
+
+// 2 -> 3: chirps from before moderation were all public, so they are approved
+func migrateAddChirpStatus(doc map[string]interface{}) error {
+	records, _ := doc["chirps"].(map[string]interface{})
+	for key, raw := range records {
+		record, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("invalid chirps record %q", key)
+		}
+		if _, ok := record["status"]; !ok {
+			record["status"] = string(ChirpStatusApproved)
+		}
+	}
+	return nil
+}
+
+// 3 -> 4: users from before roles existed are regular users
+func migrateAddUserRoles(doc map[string]interface{}) error {
+	records, _ := doc["users"].(map[string]interface{})
+	for key, raw := range records {
+		record, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("invalid users record %q", key)
+		}
+		if _, ok := record["roles"]; !ok {
+			record["roles"] = []interface{}{string(RoleUser)}
+		}
+	}
+	return nil
+}
+
+// 4 -> 5: refresh tokens became opaque and are stored with their session,
+// revocations of the old jwt refresh tokens are dropped as those tokens are
+// no longer accepted
+func migrateAddSessions(doc map[string]interface{}) error {
+	delete(doc, "revocations")
+	if _, ok := doc["sessions"]; !ok {
+		doc["sessions"] = map[string]interface{}{}
+	}
+	if _, ok := doc["refresh_tokens"]; !ok {
+		doc["refresh_tokens"] = map[string]interface{}{}
+	}
+	sequences, ok := doc["sequences"].(map[string]interface{})
+	if !ok {
+		return errors.New("invalid sequences")
+	}
+	if _, ok := sequences[seqSessions]; !ok {
+		sequences[seqSessions] = 0
+	}
+	return nil
+}
+
+// 5 -> 6: failed logins are counted per account and address
+func migrateAddLoginAttempts(doc map[string]interface{}) error {
+	if _, ok := doc["login_attempts"]; !ok {
+		doc["login_attempts"] = map[string]interface{}{}
+	}
+	return nil
+}
+
+// 6 -> 7: emails have to be verified, users from before that are trusted
+func migrateAddEmailVerified(doc map[string]interface{}) error {
+	records, _ := doc["users"].(map[string]interface{})
+	for key, raw := range records {
+		record, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("invalid users record %q", key)
+		}
+		if _, ok := record["email_verified"]; !ok {
+			record["email_verified"] = true
+		}
+	}
+	return nil
+}
+
+// 7 -> 8: users who forgot their password get emailed a reset token
+func migrateAddPasswordResets(doc map[string]interface{}) error {
+	if _, ok := doc["password_resets"]; !ok {
+		doc["password_resets"] = map[string]interface{}{}
+	}
+	return nil
+}
+
+// 8 -> 9: users make scoped api keys for bots and integrations
+func migrateAddAPIKeys(doc map[string]interface{}) error {
+	if _, ok := doc["api_keys"]; !ok {
+		doc["api_keys"] = map[string]interface{}{}
+	}
+	sequences, ok := doc["sequences"].(map[string]interface{})
+	if !ok {
+		return errors.New("invalid sequences")
+	}
+	if _, ok := sequences[seqAPIKeys]; !ok {
+		sequences[seqAPIKeys] = 0
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrResetTokenInvalid - the password reset token was already used or expired
+var ErrResetTokenInvalid = errors.New("password reset token is invalid")
+
+// PasswordReset - a token emailed to a user who forgot their password, only
+// its hash is stored
+type PasswordReset struct {
+	Hash      string    `json:"hash"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// zero until the token is used
+	UsedAt time.Time `json:"used_at"`
+}
+
+// CreatePasswordReset - stores a reset token for the user, replacing any
+// earlier token so only the newest email works
+func (db *DB) CreatePasswordReset(userID int, tokenHash string, expiresAt time.Time) (PasswordReset, error) {
+	reset := PasswordReset{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[userID]; !ok {
+			return ErrNotExist
+		}
+		for hash, old := range dbStructure.PasswordResets {
+			if old.UserID == userID {
+				delete(dbStructure.PasswordResets, hash)
+			}
+		}
+		reset = PasswordReset{
+			Hash:      tokenHash,
+			UserID:    userID,
+			CreatedAt: time.Now().UTC(),
+			ExpiresAt: expiresAt.UTC(),
+		}
+		dbStructure.PasswordResets[tokenHash] = reset
+		return nil
+	})
+	if err != nil {
+		return PasswordReset{}, err
+	}
+
+	return reset, nil
+}
+
+// ResetPassword - uses up the reset token and sets the password of its user,
+// ErrNotExist for unknown tokens and ErrResetTokenInvalid for used or expired ones
+func (db *DB) ResetPassword(tokenHash, hashedPassword string) (User, error) {
+	user := User{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		reset, ok := dbStructure.PasswordResets[tokenHash]
+		if !ok {
+			return ErrNotExist
+		}
+		now := time.Now().UTC()
+		if !reset.UsedAt.IsZero() || !now.Before(reset.ExpiresAt) {
+			return ErrResetTokenInvalid
+		}
+		user, ok = dbStructure.Users[reset.UserID]
+		if !ok {
+			return ErrNotExist
+		}
+		reset.UsedAt = now
+		dbStructure.PasswordResets[tokenHash] = reset
+		user.HashedPassword = hashedPassword
+		user.UpdatedAt = now
+		dbStructure.Users[user.ID] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// DeleteInactivePasswordResets - removes tokens that expired before cutoff,
+// returns how many were removed
+func (db *DB) DeleteInactivePasswordResets(cutoff time.Time) (int, error) {
+	removed := 0
+	err := db.Update(func(dbStructure *DBStructure) error {
+		for hash, reset := range dbStructure.PasswordResets {
+			if reset.ExpiresAt.Before(cutoff) {
+				delete(dbStructure.PasswordResets, hash)
+				removed++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
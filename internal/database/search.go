@@ -0,0 +1,345 @@
+package database
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/yuheng-liu/chirpy/internal/moderation"
+)
+
+var ErrEmptySearch = errors.New("search query has no words")
+
+// BM25 tuning, the usual defaults
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// SearchQuery - full text search with keyset pagination for SearchChirps
+type SearchQuery struct {
+	// words to look for, "quoted text" must appear as an exact phrase
+	Text string
+	// only chirps by this author, 0 for all authors
+	AuthorID int
+	// only chirps with one of these statuses, empty for every status
+	Statuses []ChirpStatus
+	// with Statuses set, also include this user's own pending chirps, 0 for none
+	ViewerID int
+	// only results after this position in the ranking, AfterID 0 to start
+	// from the best match
+	AfterScore float64
+	AfterID    int
+	// maximum number of results, 0 for no limit
+	Limit int
+}
+
+// ChirpMatch - chirp found by a search and its relevance, higher is better
+type ChirpMatch struct {
+	Chirp Chirp
+	Score float64
+}
+
+// inverted index over chirp bodies, rebuilt from the chirps on startup
+type searchIndex struct {
+	mu *sync.RWMutex
+	// term -> chirp id -> positions of the term in the body
+	postings map[string]map[int][]int
+	// chirp id -> distinct terms, so a chirp can be removed without its body
+	docTerms map[int][]string
+	// chirp id -> number of tokens in the body
+	docLengths  map[int]int
+	totalLength int
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		mu:         &sync.RWMutex{},
+		postings:   map[string]map[int][]int{},
+		docTerms:   map[int][]string{},
+		docLengths: map[int]int{},
+	}
+}
+
+// split text into normalized words the way moderation does, so "Café" and
+// "cafe" find each other and punctuation doesn't stick to words
+func tokenize(text string) []string {
+	terms := []string{}
+	for _, token := range moderation.Tokenize(text) {
+		// a word of only combining marks normalizes to nothing
+		if token.Norm != "" {
+			terms = append(terms, token.Norm)
+		}
+	}
+	return terms
+}
+
+func (index *searchIndex) add(chirp Chirp) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.removeLocked(chirp.ID)
+	tokens := tokenize(chirp.Body)
+	terms := []string{}
+	for pos, term := range tokens {
+		docs, ok := index.postings[term]
+		if !ok {
+			docs = map[int][]int{}
+			index.postings[term] = docs
+		}
+		if _, ok := docs[chirp.ID]; !ok {
+			terms = append(terms, term)
+		}
+		docs[chirp.ID] = append(docs[chirp.ID], pos)
+	}
+	index.docTerms[chirp.ID] = terms
+	index.docLengths[chirp.ID] = len(tokens)
+	index.totalLength += len(tokens)
+}
+
+func (index *searchIndex) remove(id int) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.removeLocked(id)
+}
+
+func (index *searchIndex) removeLocked(id int) {
+	terms, ok := index.docTerms[id]
+	if !ok {
+		return
+	}
+	for _, term := range terms {
+		delete(index.postings[term], id)
+		if len(index.postings[term]) == 0 {
+			delete(index.postings, term)
+		}
+	}
+	index.totalLength -= index.docLengths[id]
+	delete(index.docTerms, id)
+	delete(index.docLengths, id)
+}
+
+// index the chirps changed by a committed transaction
+func (index *searchIndex) apply(entries []logEntry) {
+	for _, entry := range entries {
+		switch entry.Op {
+		case opPutChirp:
+			index.add(*entry.Chirp)
+		case opDeleteChirp:
+			index.remove(entry.ID)
+		}
+	}
+}
+
+func (index *searchIndex) reset() {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.postings = map[string]map[int][]int{}
+	index.docTerms = map[int][]string{}
+	index.docLengths = map[int]int{}
+	index.totalLength = 0
+}
+
+// parsed search text, every term and every phrase has to match
+type parsedSearch struct {
+	terms   []string
+	phrases [][]string
+}
+
+func parseSearch(text string) (parsedSearch, error) {
+	parsed := parsedSearch{}
+	// odd segments between double quotes are phrases, the rest loose words
+	for i, segment := range strings.Split(text, `"`) {
+		tokens := tokenize(segment)
+		if len(tokens) == 0 {
+			continue
+		}
+		if i%2 == 1 && len(tokens) > 1 {
+			parsed.phrases = append(parsed.phrases, tokens)
+			continue
+		}
+		parsed.terms = append(parsed.terms, tokens...)
+	}
+	if len(parsed.terms) == 0 && len(parsed.phrases) == 0 {
+		return parsed, ErrEmptySearch
+	}
+	return parsed, nil
+}
+
+// distinct terms of the loose words and phrases, used for scoring
+func (parsed parsedSearch) allTerms() []string {
+	seen := map[string]struct{}{}
+	terms := []string{}
+	add := func(term string) {
+		if _, ok := seen[term]; !ok {
+			seen[term] = struct{}{}
+			terms = append(terms, term)
+		}
+	}
+	for _, term := range parsed.terms {
+		add(term)
+	}
+	for _, phrase := range parsed.phrases {
+		for _, term := range phrase {
+			add(term)
+		}
+	}
+	return terms
+}
+
+// ranked chirp ids matching the search, best match first, ties broken by
+// newest id first so the order is stable across pages
+func (index *searchIndex) search(parsed parsedSearch) []ChirpMatch {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	terms := parsed.allTerms()
+	// start from the rarest term to keep the candidate set small
+	sort.Slice(terms, func(i, j int) bool {
+		return len(index.postings[terms[i]]) < len(index.postings[terms[j]])
+	})
+	candidates := index.postings[terms[0]]
+
+	matches := []ChirpMatch{}
+	for id := range candidates {
+		if !index.hasAll(id, terms) || !index.hasPhrases(id, parsed.phrases) {
+			continue
+		}
+		matches = append(matches, ChirpMatch{
+			Chirp: Chirp{ID: id},
+			Score: index.score(id, terms),
+		})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matchLess(matches[i], matches[j])
+	})
+	return matches
+}
+
+func (index *searchIndex) hasAll(id int, terms []string) bool {
+	for _, term := range terms {
+		if _, ok := index.postings[term][id]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// every phrase has to appear with its words at consecutive positions
+func (index *searchIndex) hasPhrases(id int, phrases [][]string) bool {
+	for _, phrase := range phrases {
+		if !index.hasPhrase(id, phrase) {
+			return false
+		}
+	}
+	return true
+}
+
+func (index *searchIndex) hasPhrase(id int, phrase []string) bool {
+	for _, start := range index.postings[phrase[0]][id] {
+		found := true
+		for offset, term := range phrase[1:] {
+			if !containsInt(index.postings[term][id], start+offset+1) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// BM25 relevance of the chirp for the terms
+func (index *searchIndex) score(id int, terms []string) float64 {
+	docCount := float64(len(index.docLengths))
+	avgLength := float64(index.totalLength) / docCount
+	docLength := float64(index.docLengths[id])
+
+	score := 0.0
+	for _, term := range terms {
+		docFreq := float64(len(index.postings[term]))
+		termFreq := float64(len(index.postings[term][id]))
+		idf := math.Log(1 + (docCount-docFreq+0.5)/(docFreq+0.5))
+		score += idf * termFreq * (bm25K1 + 1) / (termFreq + bm25K1*(1-bm25B+bm25B*docLength/avgLength))
+	}
+	return score
+}
+
+// whether a ranks before b
+func matchLess(a, b ChirpMatch) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return a.Chirp.ID > b.Chirp.ID
+}
+
+func containsInt(values []int, target int) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
+
+// run the search and fill in one page of chirps, fetch loads the chirps with
+// the given ids from the backend, ids it can't find are skipped
+func searchChirps(index *searchIndex, query SearchQuery, fetch func(ids []int) (map[int]Chirp, error)) ([]ChirpMatch, error) {
+	parsed, err := parseSearch(query.Text)
+	if err != nil {
+		return nil, err
+	}
+	ranked := index.search(parsed)
+
+	// skip to the first result after the cursor
+	if query.AfterID != 0 {
+		cursor := ChirpMatch{Chirp: Chirp{ID: query.AfterID}, Score: query.AfterScore}
+		start := sort.Search(len(ranked), func(i int) bool {
+			return matchLess(cursor, ranked[i])
+		})
+		ranked = ranked[start:]
+	}
+
+	// load chirps in batches until the page is full, filters can drop some
+	const batchSize = 100
+	page := []ChirpMatch{}
+	for len(ranked) > 0 && (query.Limit <= 0 || len(page) < query.Limit) {
+		batch := ranked
+		if len(batch) > batchSize {
+			batch = batch[:batchSize]
+		}
+		ranked = ranked[len(batch):]
+
+		ids := make([]int, 0, len(batch))
+		for _, match := range batch {
+			ids = append(ids, match.Chirp.ID)
+		}
+		chirps, err := fetch(ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range batch {
+			chirp, ok := chirps[match.Chirp.ID]
+			if !ok {
+				continue
+			}
+			if query.AuthorID != 0 && chirp.AuthorID != query.AuthorID {
+				continue
+			}
+			if !chirp.VisibleTo(query.Statuses, query.ViewerID) {
+				continue
+			}
+			page = append(page, ChirpMatch{Chirp: chirp, Score: match.Score})
+			if query.Limit > 0 && len(page) == query.Limit {
+				break
+			}
+		}
+	}
+	return page, nil
+}
@@ -0,0 +1,211 @@
+package database
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", []string{}},
+		{"Kerfuffle!", []string{"kerfuffle"}},
+		{"hello,world\nfoo-bar", []string{"hello", "world", "foo", "bar"}},
+		{"2024 was a year", []string{"2024", "was", "a", "year"}},
+		// same folding as moderation, accents and lookalikes match plain words
+		{"Café ＦÓRNAX", []string{"cafe", "fornax"}},
+	}
+	for _, tt := range tests {
+		if got := tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseSearch(t *testing.T) {
+	tests := []struct {
+		text string
+		want parsedSearch
+	}{
+		{"go gophers", parsedSearch{terms: []string{"go", "gophers"}}},
+		{`"go gophers" run`, parsedSearch{terms: []string{"run"}, phrases: [][]string{{"go", "gophers"}}}},
+		// a quoted single word is just a word
+		{`"go"`, parsedSearch{terms: []string{"go"}}},
+		// an unclosed quote still starts a phrase
+		{`run "go gophers`, parsedSearch{terms: []string{"run"}, phrases: [][]string{{"go", "gophers"}}}},
+	}
+	for _, tt := range tests {
+		got, err := parseSearch(tt.text)
+		if err != nil {
+			t.Fatalf("parseSearch(%q): %v", tt.text, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSearch(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+	if _, err := parseSearch(`"!!" ?`); err != ErrEmptySearch {
+		t.Errorf("parseSearch without words: got %v, want ErrEmptySearch", err)
+	}
+}
+
+func createTestChirps(t *testing.T, db Store, bodies ...string) []Chirp {
+	t.Helper()
+	user, err := db.CreateUser("author@example.com", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirps := make([]Chirp, 0, len(bodies))
+	for _, body := range bodies {
+		chirp, err := db.CreateChirp(body, user.ID, ChirpStatusApproved, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps
+}
+
+func matchIDs(matches []ChirpMatch) []int {
+	ids := []int{}
+	for _, match := range matches {
+		ids = append(ids, match.Chirp.ID)
+	}
+	return ids
+}
+
+func TestSearchChirpsPhrase(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		chirps := createTestChirps(t, db,
+			"the quick brown fox",
+			"brown and quick, the fox",
+			"a quick fox, brown as ever",
+		)
+		matches, err := db.SearchChirps(SearchQuery{Text: `"quick brown" fox`})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := matchIDs(matches), []int{chirps[0].ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("phrase matched %v, want %v", got, want)
+		}
+		matches, err = db.SearchChirps(SearchQuery{Text: "quick brown fox"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) != 3 {
+			t.Errorf("loose words matched %d chirps, want 3", len(matches))
+		}
+	})
+}
+
+func TestSearchChirpsRanking(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		chirps := createTestChirps(t, db,
+			"gophers like go and many other things besides",
+			"go go gophers",
+			"nothing to see here",
+			"Go gophers",
+		)
+		matches, err := db.SearchChirps(SearchQuery{Text: "go"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// more mentions in a shorter chirp rank higher
+		want := []int{chirps[1].ID, chirps[3].ID, chirps[0].ID}
+		if got := matchIDs(matches); !reflect.DeepEqual(got, want) {
+			t.Fatalf("ranked %v, want %v", got, want)
+		}
+		for i := 1; i < len(matches); i++ {
+			if matches[i].Score > matches[i-1].Score {
+				t.Errorf("score %f ranked after %f", matches[i].Score, matches[i-1].Score)
+			}
+		}
+	})
+}
+
+func TestSearchChirpsPaging(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		// same body, so every score ties and the order is by id
+		chirps := createTestChirps(t, db, "gophers", "gophers", "gophers", "gophers", "gophers")
+		got := []int{}
+		query := SearchQuery{Text: "gophers", Limit: 2}
+		for {
+			page, err := db.SearchChirps(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page) == 0 {
+				break
+			}
+			if len(page) > 2 {
+				t.Fatalf("page of %d, limit is 2", len(page))
+			}
+			got = append(got, matchIDs(page)...)
+			last := page[len(page)-1]
+			query.AfterScore, query.AfterID = last.Score, last.Chirp.ID
+		}
+		want := []int{}
+		for i := len(chirps) - 1; i >= 0; i-- {
+			want = append(want, chirps[i].ID)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("paged through %v, want %v", got, want)
+		}
+	})
+}
+
+func TestSearchChirpsAfterDelete(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		chirps := createTestChirps(t, db, "gophers", "more gophers")
+		if err := db.DeleteChirp(chirps[0].ID); err != nil {
+			t.Fatal(err)
+		}
+		matches, err := db.SearchChirps(SearchQuery{Text: "gophers"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := matchIDs(matches); !reflect.DeepEqual(got, []int{chirps[1].ID}) {
+			t.Errorf("matched %v, want %v", got, []int{chirps[1].ID})
+		}
+	})
+}
+
+// the index changes under the db lock, concurrent writes leave it matching
+// the chirps
+func TestSearchIndexConcurrentWrites(t *testing.T) {
+	db := newTestDB(t)
+	user, err := db.CreateUser("author@example.com", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				chirp, err := db.CreateChirp("gophers", user.ID, ChirpStatusApproved, nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if j%2 == 0 {
+					if err := db.DeleteChirp(chirp.ID); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	chirps, err := db.GetChirps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(db.search.docLengths); got != len(chirps) {
+		t.Errorf("index holds %d chirps, the db %d", got, len(chirps))
+	}
+}
@@ -0,0 +1,299 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrTokenReused - a refresh token was presented after it had already been
+	// exchanged, its session has been revoked since the token probably leaked
+	ErrTokenReused = errors.New("refresh token already used")
+	// ErrSessionRevoked - the session was logged out
+	ErrSessionRevoked = errors.New("session revoked")
+	// ErrSessionExpired - the session is past its expiry
+	ErrSessionExpired = errors.New("session expired")
+)
+
+// Session - one login of a user, kept alive by exchanging refresh tokens
+type Session struct {
+	ID     int `json:"id"`
+	UserID int `json:"user_id"`
+	// device and address the user logged in from
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// zero while the session is active
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+// check - whether refresh tokens of the session may still be used at now
+func (session Session) check(now time.Time) error {
+	if !session.RevokedAt.IsZero() {
+		return ErrSessionRevoked
+	}
+	if !now.Before(session.ExpiresAt) {
+		return ErrSessionExpired
+	}
+	return nil
+}
+
+// RefreshToken - a refresh token issued for a session, only its hash is stored
+type RefreshToken struct {
+	Hash      string    `json:"hash"`
+	SessionID int       `json:"session_id"`
+	CreatedAt time.Time `json:"created_at"`
+	// when it was exchanged for a new token, zero while it is the current one
+	RotatedAt time.Time `json:"rotated_at"`
+}
+
+// CreateSession - stores a new session with its first refresh token, the id
+// and times other than ExpiresAt are filled in
+func (db *DB) CreateSession(session Session, tokenHash string) (Session, error) {
+	err := db.Update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		session.ID = dbStructure.nextID(seqSessions)
+		session.CreatedAt = now
+		session.LastUsedAt = now
+		dbStructure.Sessions[session.ID] = session
+		dbStructure.RefreshTokens[tokenHash] = RefreshToken{
+			Hash:      tokenHash,
+			SessionID: session.ID,
+			CreatedAt: now,
+		}
+		return nil
+	})
+	if err != nil {
+		return Session{}, err
+	}
+
+	return session, nil
+}
+
+// GetSession - the session with the id, ErrNotExist once it was pruned
+func (db *DB) GetSession(id int) (Session, error) {
+	session := Session{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		var ok bool
+		session, ok = dbStructure.Sessions[id]
+		if !ok {
+			return ErrNotExist
+		}
+		return nil
+	})
+	if err != nil {
+		return Session{}, err
+	}
+
+	return session, nil
+}
+
+// RotateRefreshToken - exchanges the refresh token for newTokenHash and returns
+// its session, a token that was already exchanged revokes the session and
+// gives ErrTokenReused. Tokens of the session exchanged before reuseCutoff are
+// forgotten, presenting one of them later gives ErrNotExist
+func (db *DB) RotateRefreshToken(tokenHash, newTokenHash string, reuseCutoff time.Time) (Session, error) {
+	session := Session{}
+	reused := false
+	err := db.Update(func(dbStructure *DBStructure) error {
+		token, ok := dbStructure.RefreshTokens[tokenHash]
+		if !ok {
+			return ErrNotExist
+		}
+		session, ok = dbStructure.Sessions[token.SessionID]
+		if !ok {
+			return ErrNotExist
+		}
+		now := time.Now().UTC()
+		if err := session.check(now); err != nil {
+			return err
+		}
+		// the revocation has to be committed, so the error is returned after
+		if !token.RotatedAt.IsZero() {
+			reused = true
+			session.RevokedAt = now
+			dbStructure.Sessions[session.ID] = session
+			return nil
+		}
+		for hash, old := range dbStructure.RefreshTokens {
+			if old.SessionID == session.ID && old.rotatedBefore(reuseCutoff) {
+				delete(dbStructure.RefreshTokens, hash)
+			}
+		}
+		token.RotatedAt = now
+		dbStructure.RefreshTokens[tokenHash] = token
+		dbStructure.RefreshTokens[newTokenHash] = RefreshToken{
+			Hash:      newTokenHash,
+			SessionID: session.ID,
+			CreatedAt: now,
+		}
+		session.LastUsedAt = now
+		dbStructure.Sessions[session.ID] = session
+		return nil
+	})
+	if err != nil {
+		return Session{}, err
+	}
+	if reused {
+		return session, ErrTokenReused
+	}
+
+	return session, nil
+}
+
+// RevokeSessionByToken - logs out the session the refresh token belongs to,
+// returns the id of the session
+func (db *DB) RevokeSessionByToken(tokenHash string) (int, error) {
+	sessionID := 0
+	err := db.Update(func(dbStructure *DBStructure) error {
+		token, ok := dbStructure.RefreshTokens[tokenHash]
+		if !ok {
+			return ErrNotExist
+		}
+		session, ok := dbStructure.Sessions[token.SessionID]
+		if !ok {
+			return ErrNotExist
+		}
+		if session.RevokedAt.IsZero() {
+			session.RevokedAt = time.Now().UTC()
+			dbStructure.Sessions[session.ID] = session
+		}
+		sessionID = session.ID
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return sessionID, nil
+}
+
+// ListActiveSessions - sessions of the user that are neither revoked nor
+// expired, most recently used first
+func (db *DB) ListActiveSessions(userID int) ([]Session, error) {
+	sessions := []Session{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		for _, session := range dbStructure.Sessions {
+			if session.UserID == userID && session.check(now) == nil {
+				sessions = append(sessions, session)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessionLess(sessions[i], sessions[j])
+	})
+
+	return sessions, nil
+}
+
+// most recently used first, ties broken by newest id
+func sessionLess(a, b Session) bool {
+	if !a.LastUsedAt.Equal(b.LastUsedAt) {
+		return a.LastUsedAt.After(b.LastUsedAt)
+	}
+	return a.ID > b.ID
+}
+
+// RevokeSession - logs out one session of the user, ErrNotExist if the user
+// has no such session
+func (db *DB) RevokeSession(userID, id int) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		session, ok := dbStructure.Sessions[id]
+		if !ok || session.UserID != userID {
+			return ErrNotExist
+		}
+		if session.RevokedAt.IsZero() {
+			session.RevokedAt = time.Now().UTC()
+			dbStructure.Sessions[id] = session
+		}
+		return nil
+	})
+}
+
+// RevokeUserSessions - logs out every session of the user, returns how many
+// were still active
+func (db *DB) RevokeUserSessions(userID int) (int, error) {
+	revoked := 0
+	err := db.Update(func(dbStructure *DBStructure) error {
+		now := time.Now().UTC()
+		for id, session := range dbStructure.Sessions {
+			if session.UserID != userID || session.check(now) != nil {
+				continue
+			}
+			revoked++
+			session.RevokedAt = now
+			dbStructure.Sessions[id] = session
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return revoked, nil
+}
+
+// DeleteInactiveSessions - removes sessions that expired or were revoked
+// before cutoff along with their refresh tokens, returns how many were removed
+func (db *DB) DeleteInactiveSessions(cutoff time.Time) (int, error) {
+	removed := 0
+	err := db.Update(func(dbStructure *DBStructure) error {
+		for id, session := range dbStructure.Sessions {
+			if session.inactiveBefore(cutoff) {
+				delete(dbStructure.Sessions, id)
+				removed++
+			}
+		}
+		for hash, token := range dbStructure.RefreshTokens {
+			if _, ok := dbStructure.Sessions[token.SessionID]; !ok {
+				delete(dbStructure.RefreshTokens, hash)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// DeleteRotatedRefreshTokens - removes refresh tokens exchanged before cutoff,
+// returns how many were removed
+func (db *DB) DeleteRotatedRefreshTokens(cutoff time.Time) (int, error) {
+	removed := 0
+	err := db.Update(func(dbStructure *DBStructure) error {
+		for hash, token := range dbStructure.RefreshTokens {
+			if token.rotatedBefore(cutoff) {
+				delete(dbStructure.RefreshTokens, hash)
+				removed++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// whether the token was exchanged before cutoff, the current one never was
+func (token RefreshToken) rotatedBefore(cutoff time.Time) bool {
+	return !token.RotatedAt.IsZero() && token.RotatedAt.Before(cutoff)
+}
+
+func (session Session) inactiveBefore(cutoff time.Time) bool {
+	if !session.RevokedAt.IsZero() && session.RevokedAt.Before(cutoff) {
+		return true
+	}
+	return session.ExpiresAt.Before(cutoff)
+}
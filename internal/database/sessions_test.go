@@ -0,0 +1,183 @@
+package database
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestSession(t *testing.T, db Store, tokenHash string) Session {
+	t.Helper()
+	user, err := db.CreateUser("a@example.com", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := db.CreateSession(Session{UserID: user.ID, ExpiresAt: time.Now().UTC().Add(time.Hour)}, tokenHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		created := newTestSession(t, db, "t1")
+		longAgo := time.Now().UTC().Add(-time.Hour)
+		session, err := db.RotateRefreshToken("t1", "t2", longAgo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if session.ID != created.ID {
+			t.Errorf("rotated session %d, want %d", session.ID, created.ID)
+		}
+		if _, err := db.RotateRefreshToken("t2", "t3", longAgo); err != nil {
+			t.Fatalf("exchanging the new token: %v", err)
+		}
+		if _, err := db.RotateRefreshToken("unknown", "t4", longAgo); !errors.Is(err, ErrNotExist) {
+			t.Errorf("exchanging an unknown token: got %v, want ErrNotExist", err)
+		}
+	})
+}
+
+func TestRotateRefreshTokenReuseRevokesSession(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		created := newTestSession(t, db, "t1")
+		longAgo := time.Now().UTC().Add(-time.Hour)
+		if _, err := db.RotateRefreshToken("t1", "t2", longAgo); err != nil {
+			t.Fatal(err)
+		}
+		// the old token shows up again, someone else has a copy
+		session, err := db.RotateRefreshToken("t1", "stolen", longAgo)
+		if !errors.Is(err, ErrTokenReused) {
+			t.Fatalf("exchanging t1 again: got %v, want ErrTokenReused", err)
+		}
+		if session.ID != created.ID || session.RevokedAt.IsZero() {
+			t.Errorf("reuse returned session %d revoked at %v, want %d revoked", session.ID, session.RevokedAt, created.ID)
+		}
+		// the legitimate holder is logged out too
+		if _, err := db.RotateRefreshToken("t2", "t3", longAgo); !errors.Is(err, ErrSessionRevoked) {
+			t.Errorf("exchanging t2: got %v, want ErrSessionRevoked", err)
+		}
+		active, err := db.ListActiveSessions(created.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(active) != 0 {
+			t.Errorf("%d sessions still active", len(active))
+		}
+	})
+}
+
+func TestRevokeSessionByToken(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		created := newTestSession(t, db, "t1")
+		id, err := db.RevokeSessionByToken("t1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != created.ID {
+			t.Errorf("revoked session %d, want %d", id, created.ID)
+		}
+		if _, err := db.RotateRefreshToken("t1", "t2", time.Now().UTC().Add(-time.Hour)); !errors.Is(err, ErrSessionRevoked) {
+			t.Errorf("exchanging after revoke: got %v, want ErrSessionRevoked", err)
+		}
+		if _, err := db.RevokeSessionByToken("unknown"); !errors.Is(err, ErrNotExist) {
+			t.Errorf("revoking an unknown token: got %v, want ErrNotExist", err)
+		}
+		session, err := db.GetSession(created.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if session.RevokedAt.IsZero() {
+			t.Errorf("GetSession(%d) isn't revoked", created.ID)
+		}
+		if _, err := db.GetSession(created.ID + 1); !errors.Is(err, ErrNotExist) {
+			t.Errorf("getting an unknown session: got %v, want ErrNotExist", err)
+		}
+	})
+}
+
+func TestRotateRefreshTokenForgetsOldTokens(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		newTestSession(t, db, "t1")
+		// nothing is old enough to forget yet
+		longAgo := time.Now().UTC().Add(-time.Hour)
+		if _, err := db.RotateRefreshToken("t1", "t2", longAgo); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.RotateRefreshToken("t2", "t3", longAgo); err != nil {
+			t.Fatal(err)
+		}
+		// t1 and t2 were exchanged before now, only t3 is left afterwards
+		if _, err := db.RotateRefreshToken("t3", "t4", time.Now().UTC().Add(time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		for _, hash := range []string{"t1", "t2"} {
+			_, err := db.RotateRefreshToken(hash, hash+"-again", longAgo)
+			if !errors.Is(err, ErrNotExist) {
+				t.Errorf("exchanging forgotten %s: got %v, want ErrNotExist", hash, err)
+			}
+		}
+		// t3 was exchanged inside the window and is still caught as reuse
+		_, err := db.RotateRefreshToken("t3", "t3-again", longAgo)
+		if !errors.Is(err, ErrTokenReused) {
+			t.Errorf("exchanging t3 again: got %v, want ErrTokenReused", err)
+		}
+	})
+}
+
+func TestDeleteRotatedRefreshTokens(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		newTestSession(t, db, "t1")
+		longAgo := time.Now().UTC().Add(-time.Hour)
+		if _, err := db.RotateRefreshToken("t1", "t2", longAgo); err != nil {
+			t.Fatal(err)
+		}
+		removed, err := db.DeleteRotatedRefreshTokens(time.Now().UTC().Add(time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removed != 1 {
+			t.Fatalf("removed %d tokens, want 1", removed)
+		}
+		// the current token is never removed
+		if _, err := db.RotateRefreshToken("t2", "t3", longAgo); err != nil {
+			t.Fatalf("exchanging the current token: %v", err)
+		}
+	})
+}
+
+func TestRotateRefreshTokenConcurrentExchange(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		newTestSession(t, db, "t1")
+		const exchanges = 32
+		errs := make([]error, exchanges)
+		start := make(chan struct{})
+		wg := &sync.WaitGroup{}
+		for i := 0; i < exchanges; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				<-start
+				_, errs[i] = db.RotateRefreshToken("t1", "new-"+string(rune('a'+i)), time.Now().UTC().Add(-time.Hour))
+			}(i)
+		}
+		close(start)
+		wg.Wait()
+		// one exchange wins, the others find it used or its session revoked
+		won := 0
+		for _, err := range errs {
+			switch {
+			case err == nil:
+				won++
+			case errors.Is(err, ErrTokenReused), errors.Is(err, ErrSessionRevoked):
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+		if won != 1 {
+			t.Errorf("%d exchanges succeeded, want 1", won)
+		}
+	})
+}
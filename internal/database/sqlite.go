@@ -0,0 +1,675 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	// also registers the "sqlite3" driver with database/sql
+	"github.com/mattn/go-sqlite3"
+)
+
+// SQLiteDB - Store backed by an embedded sqlite database file
+type SQLiteDB struct {
+	conn *sql.DB
+	// format of the public ids given to new chirps and users
+	idFormat IDFormat
+	// full text index over chirp bodies, kept in sync by CreateChirp and DeleteChirp
+	search *searchIndex
+	// held by writes to chirps until the index has the change too, so the
+	// index sees them in the order they were committed
+	chirpsMu *sync.Mutex
+}
+
+// tables created on startup if missing, AUTOINCREMENT keeps ids from being
+// reused after a delete
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS users (
+	id              INTEGER PRIMARY KEY AUTOINCREMENT,
+	email           TEXT NOT NULL UNIQUE,
+	hashed_password TEXT NOT NULL,
+	is_chirpy_red   INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS chirps (
+	id        INTEGER PRIMARY KEY AUTOINCREMENT,
+	author_id INTEGER NOT NULL REFERENCES users(id),
+	body      TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS sessions (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id      INTEGER NOT NULL REFERENCES users(id),
+	user_agent   TEXT NOT NULL DEFAULT '',
+	ip           TEXT NOT NULL DEFAULT '',
+	created_at   TIMESTAMP NOT NULL,
+	last_used_at TIMESTAMP NOT NULL,
+	expires_at   TIMESTAMP NOT NULL,
+	revoked_at   TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	hash       TEXT PRIMARY KEY,
+	session_id INTEGER NOT NULL REFERENCES sessions(id),
+	created_at TIMESTAMP NOT NULL,
+	rotated_at TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS password_resets (
+	hash       TEXT PRIMARY KEY,
+	user_id    INTEGER NOT NULL REFERENCES users(id),
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	used_at    TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS login_attempts (
+	key            TEXT PRIMARY KEY,
+	failures       INTEGER NOT NULL,
+	last_failed_at TIMESTAMP NOT NULL
+);
+CREATE TABLE IF NOT EXISTS api_keys (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id      INTEGER NOT NULL REFERENCES users(id),
+	name         TEXT NOT NULL,
+	prefix       TEXT NOT NULL,
+	hash         TEXT NOT NULL UNIQUE,
+	scopes       TEXT NOT NULL,
+	last_used_at TIMESTAMP,
+	created_at   TIMESTAMP NOT NULL
+);
+-- revoked jwt refresh tokens, replaced by sessions
+DROP TABLE IF EXISTS revocations;
+`
+
+// columns added after the tables were first released, added to older files
+// backfill, if set, runs once after the column is added with the current time
+var sqliteColumns = []struct {
+	table, column, definition, backfill string
+}{
+	{"users", "public_id", "TEXT", ""},
+	{"chirps", "public_id", "TEXT", ""},
+	{"users", "created_at", "TIMESTAMP", "UPDATE users SET created_at = ?"},
+	{"users", "updated_at", "TIMESTAMP", "UPDATE users SET updated_at = ?"},
+	{"chirps", "created_at", "TIMESTAMP", "UPDATE chirps SET created_at = ?"},
+	{"chirps", "updated_at", "TIMESTAMP", "UPDATE chirps SET updated_at = ?"},
+	// chirps from before moderation were all public, so they are approved
+	{"chirps", "status", "TEXT NOT NULL DEFAULT 'approved'", ""},
+	// json array of rule names
+	{"chirps", "flags", "TEXT NOT NULL DEFAULT ''", ""},
+	{"chirps", "review_reason", "TEXT NOT NULL DEFAULT ''", ""},
+	// json array of roles, users from before roles existed are regular users
+	{"users", "roles", `TEXT NOT NULL DEFAULT '["user"]'`, ""},
+	{"users", "tokens_valid_after", "TIMESTAMP", ""},
+	// users from before email verification are trusted, new ones start at 0
+	{"users", "email_verified", "INTEGER NOT NULL DEFAULT 1", ""},
+	{"users", "totp_secret", "TEXT NOT NULL DEFAULT ''", ""},
+	{"users", "totp_enabled", "INTEGER NOT NULL DEFAULT 0", ""},
+	{"users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0", ""},
+	// json array of hashes
+	{"users", "recovery_codes", "TEXT NOT NULL DEFAULT '[]'", ""},
+}
+
+// indexes created once every column exists
+const sqliteIndexes = `
+CREATE INDEX IF NOT EXISTS idx_chirps_author_id ON chirps(author_id);
+CREATE INDEX IF NOT EXISTS idx_chirps_created_at ON chirps(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_chirps_status ON chirps(status);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_id ON refresh_tokens(session_id);
+CREATE INDEX IF NOT EXISTS idx_password_resets_user_id ON password_resets(user_id);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_chirps_public_id ON chirps(public_id) WHERE public_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_public_id ON users(public_id) WHERE public_id IS NOT NULL;
+`
+
+func NewSQLiteDB(path string) (*SQLiteDB, error) {
+	// wal journal lets readers continue while a write is in progress, every
+	// transaction writes so it takes the write lock up front, upgrading a read
+	// lock later fails with SQLITE_BUSY instead of waiting out the timeout
+	conn, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	db := &SQLiteDB{
+		conn:     conn,
+		search:   newSearchIndex(),
+		chirpsMu: &sync.Mutex{},
+	}
+	err = db.ensureSchema()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	err = db.buildSearchIndex()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *SQLiteDB) ensureSchema() error {
+	_, err := db.conn.Exec(sqliteSchema)
+	if err != nil {
+		return err
+	}
+	// same backfill time for every column added in this run
+	now := time.Now().UTC()
+	for _, col := range sqliteColumns {
+		added, err := db.addColumnIfMissing(col.table, col.column, col.definition)
+		if err != nil {
+			return err
+		}
+		if added && col.backfill != "" {
+			_, err = db.conn.Exec(col.backfill, now)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err = db.conn.Exec(sqliteIndexes)
+	return err
+}
+
+// returns true if the column was missing and has been added
+func (db *SQLiteDB) addColumnIfMissing(table, column, definition string) (bool, error) {
+	rows, err := db.conn.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return false, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	_, err = db.conn.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err == nil, err
+}
+
+func (db *SQLiteDB) buildSearchIndex() error {
+	chirps, err := db.GetChirps()
+	if err != nil {
+		return err
+	}
+	for _, chirp := range chirps {
+		db.search.add(chirp)
+	}
+	return nil
+}
+
+// Close - releases the underlying database connections
+func (db *SQLiteDB) Close() error {
+	return db.conn.Close()
+}
+
+func (db *SQLiteDB) ResetDB() error {
+	db.chirpsMu.Lock()
+	defer db.chirpsMu.Unlock()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// clear every table and restart the autoincrement counters
+	for _, table := range []string{"chirps", "users", "sessions", "refresh_tokens", "password_resets", "login_attempts", "api_keys"} {
+		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM sqlite_sequence"); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	db.search.reset()
+	return nil
+}
+
+func (db *SQLiteDB) CreateChirp(body string, authorID int, status ChirpStatus, flags []string) (Chirp, error) {
+	publicID, err := newPublicID(db.idFormat)
+	if err != nil {
+		return Chirp{}, err
+	}
+	encodedFlags, err := encodeFlags(flags)
+	if err != nil {
+		return Chirp{}, err
+	}
+	now := time.Now().UTC()
+	db.chirpsMu.Lock()
+	defer db.chirpsMu.Unlock()
+	res, err := db.conn.Exec(
+		"INSERT INTO chirps (public_id, author_id, body, created_at, updated_at, status, flags) VALUES (NULLIF(?, ''), ?, ?, ?, ?, ?, ?)",
+		publicID,
+		authorID,
+		body,
+		now,
+		now,
+		status,
+		encodedFlags,
+	)
+	if err != nil {
+		return Chirp{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp := Chirp{
+		ID:        int(id),
+		PublicID:  publicID,
+		Body:      body,
+		AuthorID:  authorID,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Status:    status,
+		Flags:     flags,
+	}
+	db.search.add(chirp)
+	return chirp, nil
+}
+
+// columns scanned by scanChirp, in order
+const chirpColumns = "id, COALESCE(public_id, ''), author_id, body, created_at, updated_at, status, flags, review_reason"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanChirp(row rowScanner) (Chirp, error) {
+	chirp := Chirp{}
+	var flags string
+	err := row.Scan(
+		&chirp.ID,
+		&chirp.PublicID,
+		&chirp.AuthorID,
+		&chirp.Body,
+		&chirp.CreatedAt,
+		&chirp.UpdatedAt,
+		&chirp.Status,
+		&flags,
+		&chirp.ReviewReason,
+	)
+	if err != nil {
+		return chirp, err
+	}
+	chirp.Flags, err = decodeFlags(flags)
+	return chirp, err
+}
+
+func encodeFlags(flags []string) (string, error) {
+	if len(flags) == 0 {
+		return "", nil
+	}
+	dat, err := json.Marshal(flags)
+	return string(dat), err
+}
+
+func decodeFlags(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	flags := []string{}
+	err := json.Unmarshal([]byte(s), &flags)
+	return flags, err
+}
+
+// sql condition and args for the status filter shared by chirp queries
+func statusCondition(statuses []ChirpStatus, viewerID int) (string, []interface{}) {
+	if len(statuses) == 0 {
+		return "1 = 1", nil
+	}
+	args := make([]interface{}, 0, len(statuses)+2)
+	for _, status := range statuses {
+		args = append(args, status)
+	}
+	cond := "status IN (" + strings.Repeat("?, ", len(statuses)-1) + "?)"
+	if viewerID != 0 {
+		cond = "(" + cond + " OR (author_id = ? AND status = ?))"
+		args = append(args, viewerID, ChirpStatusPending)
+	}
+	return cond, args
+}
+
+func (db *SQLiteDB) GetChirps() ([]Chirp, error) {
+	rows, err := db.conn.Query("SELECT " + chirpColumns + " FROM chirps")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps, rows.Err()
+}
+
+func (db *SQLiteDB) ListChirps(query ChirpQuery) ([]Chirp, error) {
+	where := "1 = 1"
+	args := []interface{}{}
+	if query.AuthorID != 0 {
+		where += " AND author_id = ?"
+		args = append(args, query.AuthorID)
+	}
+	statusCond, statusArgs := statusCondition(query.Statuses, query.ViewerID)
+	where += " AND " + statusCond
+	args = append(args, statusArgs...)
+	if !query.Since.IsZero() {
+		where += " AND created_at >= ?"
+		args = append(args, query.Since.UTC())
+	}
+	if !query.Until.IsZero() {
+		where += " AND created_at < ?"
+		args = append(args, query.Until.UTC())
+	}
+	// "<" or ">" depending on direction, for the keyset condition after the cursor
+	order, after := "ASC", ">"
+	if query.Desc {
+		order, after = "DESC", "<"
+	}
+	orderBy := "id " + order
+	if query.SortBy == ChirpSortCreatedAt {
+		orderBy = "created_at " + order + ", id " + order
+		if query.AfterID != 0 {
+			where += " AND (created_at " + after + " ? OR (created_at = ? AND id " + after + " ?))"
+			cursorTime := query.AfterCreatedAt.UTC()
+			args = append(args, cursorTime, cursorTime, query.AfterID)
+		}
+	} else if query.AfterID != 0 {
+		where += " AND id " + after + " ?"
+		args = append(args, query.AfterID)
+	}
+	// a negative limit means no limit in sqlite
+	limit := -1
+	if query.Limit > 0 {
+		limit = query.Limit
+	}
+	args = append(args, limit)
+
+	rows, err := db.conn.Query("SELECT "+chirpColumns+" FROM chirps WHERE "+where+" ORDER BY "+orderBy+" LIMIT ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chirps := []Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps, rows.Err()
+}
+
+func (db *SQLiteDB) GetChirp(id int) (Chirp, error) {
+	return db.getChirpWhere("id = ?", id)
+}
+
+func (db *SQLiteDB) GetChirpByPublicID(publicID string) (Chirp, error) {
+	return db.getChirpWhere("public_id = ?", publicID)
+}
+
+func (db *SQLiteDB) getChirpWhere(cond string, arg interface{}) (Chirp, error) {
+	chirp, err := scanChirp(db.conn.QueryRow("SELECT "+chirpColumns+" FROM chirps WHERE "+cond, arg))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, ErrNotExist
+	}
+	return chirp, err
+}
+
+func (db *SQLiteDB) DeleteChirp(id int) error {
+	db.chirpsMu.Lock()
+	defer db.chirpsMu.Unlock()
+
+	_, err := db.conn.Exec("DELETE FROM chirps WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	db.search.remove(id)
+	return nil
+}
+
+func (db *SQLiteDB) SetChirpStatus(id int, status ChirpStatus, reason string) (Chirp, error) {
+	res, err := db.conn.Exec(
+		"UPDATE chirps SET status = ?, review_reason = ?, updated_at = ? WHERE id = ?",
+		status,
+		reason,
+		time.Now().UTC(),
+		id,
+	)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if err := checkAffected(res); err != nil {
+		return Chirp{}, err
+	}
+	return db.GetChirp(id)
+}
+
+func (db *SQLiteDB) SearchChirps(query SearchQuery) ([]ChirpMatch, error) {
+	return searchChirps(db.search, query, db.getChirpsByID)
+}
+
+// chirps with the given ids, missing ids are left out of the map
+func (db *SQLiteDB) getChirpsByID(ids []int) (map[int]Chirp, error) {
+	chirps := make(map[int]Chirp, len(ids))
+	if len(ids) == 0 {
+		return chirps, nil
+	}
+	placeholders := strings.Repeat("?, ", len(ids)-1) + "?"
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := db.conn.Query("SELECT "+chirpColumns+" FROM chirps WHERE id IN ("+placeholders+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps[chirp.ID] = chirp
+	}
+	return chirps, rows.Err()
+}
+
+func (db *SQLiteDB) CreateUser(email, hashedPassword string) (User, error) {
+	publicID, err := newPublicID(db.idFormat)
+	if err != nil {
+		return User{}, err
+	}
+	roles := []Role{RoleUser}
+	encodedRoles, err := encodeRoles(roles)
+	if err != nil {
+		return User{}, err
+	}
+	now := time.Now().UTC()
+	res, err := db.conn.Exec(
+		"INSERT INTO users (public_id, email, email_verified, hashed_password, roles, created_at, updated_at) VALUES (NULLIF(?, ''), ?, 0, ?, ?, ?, ?)",
+		publicID,
+		email,
+		hashedPassword,
+		encodedRoles,
+		now,
+		now,
+	)
+	if err != nil {
+		// the unique index on email rejects duplicate users
+		if isUniqueViolation(err) {
+			return User{}, ErrAlreadyExists
+		}
+		return User{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+	return User{
+		ID:             int(id),
+		PublicID:       publicID,
+		Email:          email,
+		HashedPassword: hashedPassword,
+		Roles:          roles,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}, nil
+}
+
+func (db *SQLiteDB) GetUser(id int) (User, error) {
+	return db.getUserWhere("id = ?", id)
+}
+
+func (db *SQLiteDB) GetUserByEmail(email string) (User, error) {
+	return db.getUserWhere("email = ?", email)
+}
+
+func (db *SQLiteDB) getUserWhere(cond string, arg interface{}) (User, error) {
+	user := User{}
+	var roles, recoveryCodes string
+	var tokensValidAfter sql.NullTime
+	err := db.conn.QueryRow("SELECT id, COALESCE(public_id, ''), email, email_verified, hashed_password, is_chirpy_red, roles, tokens_valid_after, totp_secret, totp_enabled, totp_last_step, recovery_codes, created_at, updated_at FROM users WHERE "+cond, arg).
+		Scan(&user.ID, &user.PublicID, &user.Email, &user.EmailVerified, &user.HashedPassword, &user.IsChirpyRed, &roles, &tokensValidAfter, &user.TOTPSecret, &user.TOTPEnabled, &user.TOTPLastStep, &recoveryCodes, &user.CreatedAt, &user.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrNotExist
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if tokensValidAfter.Valid {
+		user.TokensValidAfter = tokensValidAfter.Time
+	}
+	user.RecoveryCodes, err = decodeCodes(recoveryCodes)
+	if err != nil {
+		return User{}, err
+	}
+	user.Roles, err = decodeRoles(roles)
+	return user, err
+}
+
+func (db *SQLiteDB) RehashPassword(id int, oldHash, newHash string) error {
+	res, err := db.conn.Exec("UPDATE users SET hashed_password = ? WHERE id = ? AND hashed_password = ?", newHash, id, oldHash)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
+
+func (db *SQLiteDB) VerifyUserEmail(id int, email string) (User, error) {
+	res, err := db.conn.Exec("UPDATE users SET email_verified = 1, updated_at = ? WHERE id = ? AND email = ?", time.Now().UTC(), id, email)
+	if err != nil {
+		return User{}, err
+	}
+	if err := checkAffected(res); err != nil {
+		return User{}, err
+	}
+	return db.GetUser(id)
+}
+
+func (db *SQLiteDB) InvalidateUserTokens(id int) (User, error) {
+	now := time.Now().UTC()
+	res, err := db.conn.Exec("UPDATE users SET tokens_valid_after = ?, updated_at = ? WHERE id = ?", now, now, id)
+	if err != nil {
+		return User{}, err
+	}
+	if err := checkAffected(res); err != nil {
+		return User{}, err
+	}
+	return db.GetUser(id)
+}
+
+func (db *SQLiteDB) UpdateUser(id int, email, hashedPassword string) (User, error) {
+	// a new email has to be verified again, the case sees the old email
+	res, err := db.conn.Exec(
+		"UPDATE users SET email_verified = CASE WHEN email = ? THEN email_verified ELSE 0 END, email = ?, hashed_password = ?, updated_at = ? WHERE id = ?",
+		email,
+		email,
+		hashedPassword,
+		time.Now().UTC(),
+		id,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return User{}, ErrAlreadyExists
+		}
+		return User{}, err
+	}
+	if err := checkAffected(res); err != nil {
+		return User{}, err
+	}
+	return db.GetUser(id)
+}
+
+func (db *SQLiteDB) UpgradeChirpyRed(id int) (User, error) {
+	res, err := db.conn.Exec("UPDATE users SET is_chirpy_red = 1, updated_at = ? WHERE id = ?", time.Now().UTC(), id)
+	if err != nil {
+		return User{}, err
+	}
+	if err := checkAffected(res); err != nil {
+		return User{}, err
+	}
+	return db.GetUser(id)
+}
+
+func (db *SQLiteDB) SetUserRoles(id int, roles []Role) (User, error) {
+	encodedRoles, err := encodeRoles(roles)
+	if err != nil {
+		return User{}, err
+	}
+	res, err := db.conn.Exec("UPDATE users SET roles = ?, updated_at = ? WHERE id = ?", encodedRoles, time.Now().UTC(), id)
+	if err != nil {
+		return User{}, err
+	}
+	if err := checkAffected(res); err != nil {
+		return User{}, err
+	}
+	return db.GetUser(id)
+}
+
+func encodeRoles(roles []Role) (string, error) {
+	if roles == nil {
+		roles = []Role{}
+	}
+	dat, err := json.Marshal(roles)
+	return string(dat), err
+}
+
+func decodeRoles(s string) ([]Role, error) {
+	roles := []Role{}
+	err := json.Unmarshal([]byte(s), &roles)
+	return roles, err
+}
+
+// returns ErrNotExist when an update statement matched no rows
+func checkAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotExist
+	}
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	sqliteErr := sqlite3.Error{}
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+func (db *SQLiteDB) CreateAPIKey(key APIKey) (APIKey, error) {
+	encodedScopes, err := encodeScopes(key.Scopes)
+	if err != nil {
+		return APIKey{}, err
+	}
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return APIKey{}, err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRow("SELECT 1 FROM users WHERE id = ?", key.UserID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, ErrNotExist
+	}
+	if err != nil {
+		return APIKey{}, err
+	}
+	now := time.Now().UTC()
+	res, err := tx.Exec(
+		"INSERT INTO api_keys (user_id, name, prefix, hash, scopes, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		key.Hash,
+		encodedScopes,
+		now,
+	)
+	if err != nil {
+		return APIKey{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return APIKey{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return APIKey{}, err
+	}
+	key.ID = int(id)
+	key.CreatedAt = now
+	key.LastUsedAt = time.Time{}
+	return key, nil
+}
+
+const apiKeyColumns = "id, user_id, name, prefix, hash, scopes, last_used_at, created_at"
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	key := APIKey{}
+	var scopes string
+	var lastUsedAt sql.NullTime
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.Hash,
+		&scopes,
+		&lastUsedAt,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		return APIKey{}, err
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = lastUsedAt.Time
+	}
+	key.Scopes, err = decodeScopes(scopes)
+	return key, err
+}
+
+func (db *SQLiteDB) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	key, err := scanAPIKey(db.conn.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE hash = ?", keyHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, ErrNotExist
+	}
+	return key, err
+}
+
+func (db *SQLiteDB) ListAPIKeys(userID int) ([]APIKey, error) {
+	rows, err := db.conn.Query("SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = ? ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (db *SQLiteDB) DeleteAPIKey(userID, id int) error {
+	res, err := db.conn.Exec("DELETE FROM api_keys WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
+
+func (db *SQLiteDB) RevokeUserAPIKeys(userID int) (int, error) {
+	res, err := db.conn.Exec("DELETE FROM api_keys WHERE user_id = ?", userID)
+	if err != nil {
+		return 0, err
+	}
+	revoked, err := res.RowsAffected()
+	return int(revoked), err
+}
+
+func (db *SQLiteDB) TouchAPIKey(keyHash string, usedAt time.Time) error {
+	res, err := db.conn.Exec("UPDATE api_keys SET last_used_at = ? WHERE hash = ?", usedAt.UTC(), keyHash)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
+
+func encodeScopes(scopes []Scope) (string, error) {
+	if scopes == nil {
+		scopes = []Scope{}
+	}
+	dat, err := json.Marshal(scopes)
+	return string(dat), err
+}
+
+func decodeScopes(s string) ([]Scope, error) {
+	scopes := []Scope{}
+	err := json.Unmarshal([]byte(s), &scopes)
+	return scopes, err
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+func (db *SQLiteDB) GetLoginAttempts(key string) (LoginAttempts, error) {
+	attempts := LoginAttempts{Key: key}
+	err := db.conn.QueryRow("SELECT failures, last_failed_at FROM login_attempts WHERE key = ?", key).
+		Scan(&attempts.Failures, &attempts.LastFailedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return LoginAttempts{Key: key}, nil
+	}
+	if err != nil {
+		return LoginAttempts{}, err
+	}
+	return attempts, nil
+}
+
+func (db *SQLiteDB) RecordLoginFailure(key string, at, resetBefore time.Time) (LoginAttempts, error) {
+	attempts := LoginAttempts{Key: key}
+	// a single statement, so concurrent failures are all counted
+	err := db.conn.QueryRow(
+		`INSERT INTO login_attempts (key, failures, last_failed_at) VALUES (?, 1, ?)
+		ON CONFLICT(key) DO UPDATE SET
+			failures = CASE WHEN last_failed_at < ? THEN 1 ELSE failures + 1 END,
+			last_failed_at = excluded.last_failed_at
+		RETURNING failures, last_failed_at`,
+		key,
+		at.UTC(),
+		resetBefore.UTC(),
+	).Scan(&attempts.Failures, &attempts.LastFailedAt)
+	if err != nil {
+		return LoginAttempts{}, err
+	}
+	return attempts, nil
+}
+
+func (db *SQLiteDB) ClearLoginAttempts(key string) error {
+	_, err := db.conn.Exec("DELETE FROM login_attempts WHERE key = ?", key)
+	return err
+}
+
+func (db *SQLiteDB) DeleteStaleLoginAttempts(cutoff time.Time) (int, error) {
+	res, err := db.conn.Exec("DELETE FROM login_attempts WHERE last_failed_at < ?", cutoff.UTC())
+	if err != nil {
+		return 0, err
+	}
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(removed), nil
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+func (db *SQLiteDB) CreatePasswordReset(userID int, tokenHash string, expiresAt time.Time) (PasswordReset, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return PasswordReset{}, err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRow("SELECT 1 FROM users WHERE id = ?", userID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PasswordReset{}, ErrNotExist
+	}
+	if err != nil {
+		return PasswordReset{}, err
+	}
+	// only the newest email works
+	_, err = tx.Exec("DELETE FROM password_resets WHERE user_id = ?", userID)
+	if err != nil {
+		return PasswordReset{}, err
+	}
+	reset := PasswordReset{
+		Hash:      tokenHash,
+		UserID:    userID,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt.UTC(),
+	}
+	_, err = tx.Exec(
+		"INSERT INTO password_resets (hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		reset.Hash,
+		reset.UserID,
+		reset.CreatedAt,
+		reset.ExpiresAt,
+	)
+	if err != nil {
+		return PasswordReset{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return PasswordReset{}, err
+	}
+	return reset, nil
+}
+
+func (db *SQLiteDB) ResetPassword(tokenHash, hashedPassword string) (User, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	reset := PasswordReset{Hash: tokenHash}
+	var usedAt sql.NullTime
+	err = tx.QueryRow("SELECT user_id, expires_at, used_at FROM password_resets WHERE hash = ?", tokenHash).
+		Scan(&reset.UserID, &reset.ExpiresAt, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrNotExist
+	}
+	if err != nil {
+		return User{}, err
+	}
+	now := time.Now().UTC()
+	if usedAt.Valid || !now.Before(reset.ExpiresAt) {
+		return User{}, ErrResetTokenInvalid
+	}
+	// the used_at check makes a concurrent reset with the same token lose
+	res, err := tx.Exec("UPDATE password_resets SET used_at = ? WHERE hash = ? AND used_at IS NULL", now, tokenHash)
+	if err != nil {
+		return User{}, err
+	}
+	if err := checkAffected(res); err != nil {
+		return User{}, ErrResetTokenInvalid
+	}
+	res, err = tx.Exec("UPDATE users SET hashed_password = ?, updated_at = ? WHERE id = ?", hashedPassword, now, reset.UserID)
+	if err != nil {
+		return User{}, err
+	}
+	if err := checkAffected(res); err != nil {
+		return User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
+	return db.GetUser(reset.UserID)
+}
+
+func (db *SQLiteDB) DeleteInactivePasswordResets(cutoff time.Time) (int, error) {
+	res, err := db.conn.Exec("DELETE FROM password_resets WHERE expires_at < ?", cutoff.UTC())
+	if err != nil {
+		return 0, err
+	}
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(removed), nil
+}
@@ -0,0 +1,261 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+func (db *SQLiteDB) CreateSession(session Session, tokenHash string) (Session, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Session{}, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	res, err := tx.Exec(
+		"INSERT INTO sessions (user_id, user_agent, ip, created_at, last_used_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		session.UserID,
+		session.UserAgent,
+		session.IP,
+		now,
+		now,
+		session.ExpiresAt,
+	)
+	if err != nil {
+		return Session{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Session{}, err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO refresh_tokens (hash, session_id, created_at) VALUES (?, ?, ?)",
+		tokenHash,
+		id,
+		now,
+	)
+	if err != nil {
+		return Session{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Session{}, err
+	}
+	session.ID = int(id)
+	session.CreatedAt = now
+	session.LastUsedAt = now
+	return session, nil
+}
+
+const sessionColumns = "id, user_id, user_agent, ip, created_at, last_used_at, expires_at, revoked_at"
+
+func scanSession(row rowScanner) (Session, error) {
+	session := Session{}
+	var revokedAt sql.NullTime
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.UserAgent,
+		&session.IP,
+		&session.CreatedAt,
+		&session.LastUsedAt,
+		&session.ExpiresAt,
+		&revokedAt,
+	)
+	if revokedAt.Valid {
+		session.RevokedAt = revokedAt.Time
+	}
+	return session, err
+}
+
+func (db *SQLiteDB) GetSession(id int) (Session, error) {
+	session, err := scanSession(db.conn.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, ErrNotExist
+	}
+	return session, err
+}
+
+// session of the refresh token and whether the token was already rotated
+func sessionByToken(tx *sql.Tx, tokenHash string) (Session, bool, error) {
+	var sessionID int
+	var rotatedAt sql.NullTime
+	err := tx.QueryRow("SELECT session_id, rotated_at FROM refresh_tokens WHERE hash = ?", tokenHash).Scan(&sessionID, &rotatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, false, ErrNotExist
+	}
+	if err != nil {
+		return Session{}, false, err
+	}
+	session, err := scanSession(tx.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE id = ?", sessionID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, false, ErrNotExist
+	}
+	return session, rotatedAt.Valid, err
+}
+
+func (db *SQLiteDB) RotateRefreshToken(tokenHash, newTokenHash string, reuseCutoff time.Time) (Session, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return Session{}, err
+	}
+	defer tx.Rollback()
+
+	session, rotated, err := sessionByToken(tx, tokenHash)
+	if err != nil {
+		return Session{}, err
+	}
+	now := time.Now().UTC()
+	if err := session.check(now); err != nil {
+		return Session{}, err
+	}
+	if !rotated {
+		// only one of two concurrent exchanges of the same token can win, the
+		// other one is treated as reuse below
+		res, err := tx.Exec("UPDATE refresh_tokens SET rotated_at = ? WHERE hash = ? AND rotated_at IS NULL", now, tokenHash)
+		if err != nil {
+			return Session{}, err
+		}
+		if err := checkAffected(res); errors.Is(err, ErrNotExist) {
+			rotated = true
+		} else if err != nil {
+			return Session{}, err
+		}
+	}
+	if rotated {
+		_, err = tx.Exec("UPDATE sessions SET revoked_at = ? WHERE id = ?", now, session.ID)
+		if err != nil {
+			return Session{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return Session{}, err
+		}
+		session.RevokedAt = now
+		return session, ErrTokenReused
+	}
+	_, err = tx.Exec("DELETE FROM refresh_tokens WHERE session_id = ? AND rotated_at < ? AND hash != ?", session.ID, reuseCutoff, tokenHash)
+	if err != nil {
+		return Session{}, err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO refresh_tokens (hash, session_id, created_at) VALUES (?, ?, ?)",
+		newTokenHash,
+		session.ID,
+		now,
+	)
+	if err != nil {
+		return Session{}, err
+	}
+	_, err = tx.Exec("UPDATE sessions SET last_used_at = ? WHERE id = ?", now, session.ID)
+	if err != nil {
+		return Session{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Session{}, err
+	}
+	session.LastUsedAt = now
+	return session, nil
+}
+
+func (db *SQLiteDB) RevokeSessionByToken(tokenHash string) (int, error) {
+	var sessionID int
+	err := db.conn.QueryRow(
+		"UPDATE sessions SET revoked_at = COALESCE(revoked_at, ?) WHERE id = (SELECT session_id FROM refresh_tokens WHERE hash = ?) RETURNING id",
+		time.Now().UTC(),
+		tokenHash,
+	).Scan(&sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotExist
+	}
+	if err != nil {
+		return 0, err
+	}
+	return sessionID, nil
+}
+
+func (db *SQLiteDB) ListActiveSessions(userID int) ([]Session, error) {
+	rows, err := db.conn.Query(
+		"SELECT "+sessionColumns+" FROM sessions WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ? ORDER BY last_used_at DESC, id DESC",
+		userID,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (db *SQLiteDB) RevokeSession(userID, id int) error {
+	res, err := db.conn.Exec(
+		"UPDATE sessions SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ? AND user_id = ?",
+		time.Now().UTC(),
+		id,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
+
+func (db *SQLiteDB) RevokeUserSessions(userID int) (int, error) {
+	now := time.Now().UTC()
+	res, err := db.conn.Exec(
+		"UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?",
+		now,
+		userID,
+		now,
+	)
+	if err != nil {
+		return 0, err
+	}
+	revoked, err := res.RowsAffected()
+	return int(revoked), err
+}
+
+func (db *SQLiteDB) DeleteRotatedRefreshTokens(cutoff time.Time) (int, error) {
+	res, err := db.conn.Exec("DELETE FROM refresh_tokens WHERE rotated_at < ?", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := res.RowsAffected()
+	return int(removed), err
+}
+
+func (db *SQLiteDB) DeleteInactiveSessions(cutoff time.Time) (int, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// foreign keys aren't enforced, so the tokens are removed explicitly
+	const inactive = "expires_at < ? OR revoked_at < ?"
+	_, err = tx.Exec("DELETE FROM refresh_tokens WHERE session_id IN (SELECT id FROM sessions WHERE "+inactive+")", cutoff, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec("DELETE FROM sessions WHERE "+inactive, cutoff, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int(removed), nil
+}
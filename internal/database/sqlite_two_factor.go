@@ -0,0 +1,131 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+func (db *SQLiteDB) StartTOTPEnrollment(id int, secret string) (User, error) {
+	res, err := db.conn.Exec("UPDATE users SET totp_secret = ?, updated_at = ? WHERE id = ? AND totp_enabled = 0", secret, time.Now().UTC(), id)
+	if err != nil {
+		return User{}, err
+	}
+	if err := checkAffected(res); err != nil {
+		return User{}, db.totpConflict(id)
+	}
+	return db.GetUser(id)
+}
+
+func (db *SQLiteDB) EnableTOTP(id int, secret string, step int64, recoveryCodes []string) (User, error) {
+	encodedCodes, err := encodeCodes(recoveryCodes)
+	if err != nil {
+		return User{}, err
+	}
+	res, err := db.conn.Exec(
+		"UPDATE users SET totp_enabled = 1, totp_last_step = ?, recovery_codes = ?, updated_at = ? WHERE id = ? AND totp_enabled = 0 AND totp_secret = ?",
+		step,
+		encodedCodes,
+		time.Now().UTC(),
+		id,
+		secret,
+	)
+	if err != nil {
+		return User{}, err
+	}
+	if err := checkAffected(res); err != nil {
+		return User{}, db.totpConflict(id)
+	}
+	return db.GetUser(id)
+}
+
+// why an update guarded by totp_enabled = 0 matched nothing
+func (db *SQLiteDB) totpConflict(id int) error {
+	user, err := db.GetUser(id)
+	if err != nil {
+		return err
+	}
+	if user.TOTPEnabled {
+		return ErrTwoFactorEnabled
+	}
+	return ErrNotExist
+}
+
+func (db *SQLiteDB) DisableTOTP(id int) (User, error) {
+	res, err := db.conn.Exec(
+		"UPDATE users SET totp_secret = '', totp_enabled = 0, totp_last_step = 0, recovery_codes = '[]', updated_at = ? WHERE id = ?",
+		time.Now().UTC(),
+		id,
+	)
+	if err != nil {
+		return User{}, err
+	}
+	if err := checkAffected(res); err != nil {
+		return User{}, err
+	}
+	return db.GetUser(id)
+}
+
+func (db *SQLiteDB) UseTOTPStep(id int, step int64) error {
+	res, err := db.conn.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, id, step)
+	if err != nil {
+		return err
+	}
+	if err := checkAffected(res); err != nil {
+		// the user is gone or a code for this step was used already
+		if _, err := db.GetUser(id); err != nil {
+			return err
+		}
+		return ErrCodeUsed
+	}
+	return nil
+}
+
+func (db *SQLiteDB) UseRecoveryCode(id int, codeHash string) error {
+	for {
+		var encodedCodes string
+		err := db.conn.QueryRow("SELECT recovery_codes FROM users WHERE id = ?", id).Scan(&encodedCodes)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotExist
+		}
+		if err != nil {
+			return err
+		}
+		codes, err := decodeCodes(encodedCodes)
+		if err != nil {
+			return err
+		}
+		remaining, ok := withoutCode(codes, codeHash)
+		if !ok {
+			return ErrCodeUsed
+		}
+		encodedRemaining, err := encodeCodes(remaining)
+		if err != nil {
+			return err
+		}
+		// only replaces the codes that were read, another code used meanwhile
+		// means reading them again
+		res, err := db.conn.Exec("UPDATE users SET recovery_codes = ? WHERE id = ? AND recovery_codes = ?", encodedRemaining, id, encodedCodes)
+		if err != nil {
+			return err
+		}
+		if checkAffected(res) == nil {
+			return nil
+		}
+	}
+}
+
+func encodeCodes(codes []string) (string, error) {
+	if codes == nil {
+		codes = []string{}
+	}
+	dat, err := json.Marshal(codes)
+	return string(dat), err
+}
+
+func decodeCodes(s string) ([]string, error) {
+	codes := []string{}
+	err := json.Unmarshal([]byte(s), &codes)
+	return codes, err
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrUnknownDriver   = errors.New("unknown database driver")
+	ErrUnknownIDFormat = errors.New("unknown id format")
+)
+
+// Store is the set of operations the handlers need from a storage backend,
+// implemented by both the json file DB and the sqlite SQLiteDB
+type Store interface {
+	// chirps
+	CreateChirp(body string, authorID int, status ChirpStatus, flags []string) (Chirp, error)
+	GetChirps() ([]Chirp, error)
+	ListChirps(query ChirpQuery) ([]Chirp, error)
+	SearchChirps(query SearchQuery) ([]ChirpMatch, error)
+	GetChirp(id int) (Chirp, error)
+	GetChirpByPublicID(publicID string) (Chirp, error)
+	DeleteChirp(id int) error
+	SetChirpStatus(id int, status ChirpStatus, reason string) (Chirp, error)
+	// users
+	CreateUser(email, hashedPassword string) (User, error)
+	GetUser(id int) (User, error)
+	GetUserByEmail(email string) (User, error)
+	UpdateUser(id int, email, hashedPassword string) (User, error)
+	UpgradeChirpyRed(id int) (User, error)
+	SetUserRoles(id int, roles []Role) (User, error)
+	VerifyUserEmail(id int, email string) (User, error)
+	RehashPassword(id int, oldHash, newHash string) error
+	InvalidateUserTokens(id int) (User, error)
+	// two-factor authentication, recovery codes are identified by their hash
+	StartTOTPEnrollment(id int, secret string) (User, error)
+	EnableTOTP(id int, secret string, step int64, recoveryCodes []string) (User, error)
+	DisableTOTP(id int) (User, error)
+	UseTOTPStep(id int, step int64) error
+	UseRecoveryCode(id int, codeHash string) error
+	// api keys of users, identified by their hash
+	CreateAPIKey(key APIKey) (APIKey, error)
+	GetAPIKeyByHash(keyHash string) (APIKey, error)
+	ListAPIKeys(userID int) ([]APIKey, error)
+	DeleteAPIKey(userID, id int) error
+	RevokeUserAPIKeys(userID int) (int, error)
+	TouchAPIKey(keyHash string, usedAt time.Time) error
+	// sessions, refresh tokens are identified by their hash
+	CreateSession(session Session, tokenHash string) (Session, error)
+	GetSession(id int) (Session, error)
+	RotateRefreshToken(tokenHash, newTokenHash string, reuseCutoff time.Time) (Session, error)
+	RevokeSessionByToken(tokenHash string) (int, error)
+	ListActiveSessions(userID int) ([]Session, error)
+	RevokeSession(userID, id int) error
+	RevokeUserSessions(userID int) (int, error)
+	DeleteInactiveSessions(cutoff time.Time) (int, error)
+	DeleteRotatedRefreshTokens(cutoff time.Time) (int, error)
+	// password reset tokens, identified by their hash
+	CreatePasswordReset(userID int, tokenHash string, expiresAt time.Time) (PasswordReset, error)
+	ResetPassword(tokenHash, hashedPassword string) (User, error)
+	DeleteInactivePasswordResets(cutoff time.Time) (int, error)
+	// failed logins, keyed by account or address
+	GetLoginAttempts(key string) (LoginAttempts, error)
+	RecordLoginFailure(key string, at, resetBefore time.Time) (LoginAttempts, error)
+	ClearLoginAttempts(key string) error
+	DeleteStaleLoginAttempts(cutoff time.Time) (int, error)
+	// deletes all stored data and starts over with an empty store
+	ResetDB() error
+}
+
+// compile time checks that both backends satisfy Store
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*SQLiteDB)(nil)
+)
+
+// Config - selects and configures the storage backend
+type Config struct {
+	// "json" (default) or "sqlite"
+	Driver string
+	// file the backend stores its data in
+	Path string
+	// format of the public ids given to new chirps and users
+	IDFormat IDFormat
+}
+
+// NewStore - opens the storage backend described by cfg
+func NewStore(cfg Config) (Store, error) {
+	switch cfg.IDFormat {
+	case IDFormatNone, IDFormatUUIDv7:
+	default:
+		return nil, ErrUnknownIDFormat
+	}
+	switch cfg.Driver {
+	case "", "json":
+		db, err := NewDB(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		db.idFormat = cfg.IDFormat
+		return db, nil
+	case "sqlite":
+		db, err := NewSQLiteDB(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		db.idFormat = cfg.IDFormat
+		return db, nil
+	default:
+		return nil, ErrUnknownDriver
+	}
+}
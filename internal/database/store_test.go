@@ -0,0 +1,21 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// run test against a fresh database of every driver
+func forEachStore(t *testing.T, test func(t *testing.T, db Store)) {
+	t.Run("json", func(t *testing.T) {
+		test(t, newTestDB(t))
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := NewSQLiteDB(filepath.Join(t.TempDir(), "database.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		test(t, db)
+	})
+}
@@ -0,0 +1,101 @@
+package database
+
+import "errors"
+
+var (
+	// ErrTwoFactorEnabled - the user already finished enrolling
+	ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrCodeUsed - the totp code or recovery code was already used
+	ErrCodeUsed = errors.New("code was already used")
+)
+
+// StartTOTPEnrollment - stores a new totp secret for the user to confirm,
+// replacing the secret of an unfinished enrollment
+func (db *DB) StartTOTPEnrollment(id int, secret string) (User, error) {
+	return db.updateUser(id, func(user *User) error {
+		if user.TOTPEnabled {
+			return ErrTwoFactorEnabled
+		}
+		user.TOTPSecret = secret
+		return nil
+	})
+}
+
+// EnableTOTP - turns on two-factor authentication once the user proved their
+// authenticator works, ErrNotExist if the enrollment was restarted with
+// another secret meanwhile
+func (db *DB) EnableTOTP(id int, secret string, step int64, recoveryCodes []string) (User, error) {
+	return db.updateUser(id, func(user *User) error {
+		if user.TOTPEnabled {
+			return ErrTwoFactorEnabled
+		}
+		if user.TOTPSecret != secret {
+			return ErrNotExist
+		}
+		user.TOTPEnabled = true
+		user.TOTPLastStep = step
+		user.RecoveryCodes = recoveryCodes
+		return nil
+	})
+}
+
+// DisableTOTP - turns off two-factor authentication and forgets the secret
+// and recovery codes
+func (db *DB) DisableTOTP(id int) (User, error) {
+	return db.updateUser(id, func(user *User) error {
+		user.TOTPSecret = ""
+		user.TOTPEnabled = false
+		user.TOTPLastStep = 0
+		user.RecoveryCodes = nil
+		return nil
+	})
+}
+
+// UseTOTPStep - records that the code for step was used, ErrCodeUsed if a
+// code for it or a later step was accepted before
+func (db *DB) UseTOTPStep(id int, step int64) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		user, ok := dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+		if step <= user.TOTPLastStep {
+			return ErrCodeUsed
+		}
+		user.TOTPLastStep = step
+		dbStructure.Users[id] = user
+		return nil
+	})
+}
+
+// UseRecoveryCode - removes the recovery code with the hash from the user,
+// ErrCodeUsed if the user has no such code left
+func (db *DB) UseRecoveryCode(id int, codeHash string) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		user, ok := dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+		remaining, ok := withoutCode(user.RecoveryCodes, codeHash)
+		if !ok {
+			return ErrCodeUsed
+		}
+		user.RecoveryCodes = remaining
+		dbStructure.Users[id] = user
+		return nil
+	})
+}
+
+// copy of codes without codeHash, false if it isn't in codes
+func withoutCode(codes []string, codeHash string) ([]string, bool) {
+	remaining := make([]string, 0, len(codes))
+	found := false
+	for _, code := range codes {
+		if code == codeHash && !found {
+			found = true
+			continue
+		}
+		remaining = append(remaining, code)
+	}
+	return remaining, found
+}
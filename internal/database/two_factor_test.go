@@ -0,0 +1,99 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func newTestTwoFactorUser(t *testing.T, db Store, codes []string) User {
+	t.Helper()
+	user, err := db.CreateUser("a@example.com", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.StartTOTPEnrollment(user.ID, "SECRET"); err != nil {
+		t.Fatal(err)
+	}
+	user, err = db.EnableTOTP(user.ID, "SECRET", 100, codes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestEnableTOTP(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		user, err := db.CreateUser("a@example.com", "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.StartTOTPEnrollment(user.ID, "FIRST"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.StartTOTPEnrollment(user.ID, "SECOND"); err != nil {
+			t.Fatal(err)
+		}
+		// confirming with the secret of the replaced enrollment fails
+		if _, err := db.EnableTOTP(user.ID, "FIRST", 100, nil); !errors.Is(err, ErrNotExist) {
+			t.Errorf("enabling the replaced secret: got %v, want ErrNotExist", err)
+		}
+		user, err = db.EnableTOTP(user.ID, "SECOND", 100, []string{"h1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !user.TOTPEnabled || user.TOTPLastStep != 100 {
+			t.Errorf("enabled %v at step %d, want enabled at 100", user.TOTPEnabled, user.TOTPLastStep)
+		}
+		if _, err := db.StartTOTPEnrollment(user.ID, "THIRD"); !errors.Is(err, ErrTwoFactorEnabled) {
+			t.Errorf("enrolling again: got %v, want ErrTwoFactorEnabled", err)
+		}
+	})
+}
+
+func TestUseTOTPStep(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		user := newTestTwoFactorUser(t, db, nil)
+		// the code that confirmed enrollment can't log in
+		if err := db.UseTOTPStep(user.ID, 100); !errors.Is(err, ErrCodeUsed) {
+			t.Errorf("step 100 again: got %v, want ErrCodeUsed", err)
+		}
+		if err := db.UseTOTPStep(user.ID, 101); err != nil {
+			t.Fatal(err)
+		}
+		for _, step := range []int64{101, 100} {
+			if err := db.UseTOTPStep(user.ID, step); !errors.Is(err, ErrCodeUsed) {
+				t.Errorf("step %d after 101: got %v, want ErrCodeUsed", step, err)
+			}
+		}
+	})
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		user := newTestTwoFactorUser(t, db, []string{"h1", "h2"})
+		if err := db.UseRecoveryCode(user.ID, "h1"); err != nil {
+			t.Fatal(err)
+		}
+		// every code works once
+		if err := db.UseRecoveryCode(user.ID, "h1"); !errors.Is(err, ErrCodeUsed) {
+			t.Errorf("h1 again: got %v, want ErrCodeUsed", err)
+		}
+		if err := db.UseRecoveryCode(user.ID, "unknown"); !errors.Is(err, ErrCodeUsed) {
+			t.Errorf("unknown code: got %v, want ErrCodeUsed", err)
+		}
+		user, err := db.GetUser(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(user.RecoveryCodes) != 1 || user.RecoveryCodes[0] != "h2" {
+			t.Errorf("codes left %v, want [h2]", user.RecoveryCodes)
+		}
+		// turning two-factor off forgets the remaining codes
+		if _, err := db.DisableTOTP(user.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.UseRecoveryCode(user.ID, "h2"); !errors.Is(err, ErrCodeUsed) {
+			t.Errorf("h2 after disabling: got %v, want ErrCodeUsed", err)
+		}
+	})
+}
@@ -0,0 +1,189 @@
+package database
+
+import "reflect"
+
+// View - runs fn against the cached db while holding the read lock
+// fn must not modify dbStructure or keep references to it after returning
+func (db *DB) View(fn func(dbStructure *DBStructure) error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return fn(&db.data)
+}
+
+// Update - runs a read-modify-write transaction while holding the write lock
+// fn works on a copy of the db, which is persisted and becomes the new cache
+// only if fn returns nil, so a failed transaction leaves no partial changes
+// records must be replaced in their maps rather than modified in place
+func (db *DB) Update(fn func(dbStructure *DBStructure) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	next := db.data.clone()
+	err := fn(&next)
+	if err != nil {
+		return err
+	}
+	// nothing to persist if the transaction only read
+	entries := diffDB(db.data, next)
+	if len(entries) == 0 {
+		return nil
+	}
+	err = db.writeDB(next, entries...)
+	if err != nil {
+		return err
+	}
+	db.data = next
+	// still under the lock, so the index changes in the same order as the data
+	db.search.apply(entries)
+	return nil
+}
+
+// copy of the maps so a transaction can't touch the cache directly
+func (dbStructure DBStructure) clone() DBStructure {
+	cloned := DBStructure{
+		SchemaVersion:  dbStructure.SchemaVersion,
+		Chirps:         make(map[int]Chirp, len(dbStructure.Chirps)),
+		Users:          make(map[int]User, len(dbStructure.Users)),
+		Sessions:       make(map[int]Session, len(dbStructure.Sessions)),
+		RefreshTokens:  make(map[string]RefreshToken, len(dbStructure.RefreshTokens)),
+		PasswordResets: make(map[string]PasswordReset, len(dbStructure.PasswordResets)),
+		LoginAttempts:  make(map[string]LoginAttempts, len(dbStructure.LoginAttempts)),
+		APIKeys:        make(map[string]APIKey, len(dbStructure.APIKeys)),
+		Sequences:      make(map[string]int, len(dbStructure.Sequences)),
+	}
+	for id, chirp := range dbStructure.Chirps {
+		cloned.Chirps[id] = chirp
+	}
+	for id, user := range dbStructure.Users {
+		cloned.Users[id] = user
+	}
+	for id, session := range dbStructure.Sessions {
+		cloned.Sessions[id] = session
+	}
+	for hash, token := range dbStructure.RefreshTokens {
+		cloned.RefreshTokens[hash] = token
+	}
+	for hash, reset := range dbStructure.PasswordResets {
+		cloned.PasswordResets[hash] = reset
+	}
+	for key, attempts := range dbStructure.LoginAttempts {
+		cloned.LoginAttempts[key] = attempts
+	}
+	for hash, key := range dbStructure.APIKeys {
+		cloned.APIKeys[hash] = key
+	}
+	for table, seq := range dbStructure.Sequences {
+		cloned.Sequences[table] = seq
+	}
+	return cloned
+}
+
+// make sure every map is usable, json decoding leaves missing keys as nil
+func (dbStructure *DBStructure) ensureMaps() {
+	if dbStructure.Chirps == nil {
+		dbStructure.Chirps = map[int]Chirp{}
+	}
+	if dbStructure.Users == nil {
+		dbStructure.Users = map[int]User{}
+	}
+	if dbStructure.Sessions == nil {
+		dbStructure.Sessions = map[int]Session{}
+	}
+	if dbStructure.RefreshTokens == nil {
+		dbStructure.RefreshTokens = map[string]RefreshToken{}
+	}
+	if dbStructure.PasswordResets == nil {
+		dbStructure.PasswordResets = map[string]PasswordReset{}
+	}
+	if dbStructure.LoginAttempts == nil {
+		dbStructure.LoginAttempts = map[string]LoginAttempts{}
+	}
+	if dbStructure.APIKeys == nil {
+		dbStructure.APIKeys = map[string]APIKey{}
+	}
+	if dbStructure.Sequences == nil {
+		dbStructure.Sequences = map[string]int{}
+	}
+}
+
+// log entries that turn prev into next
+func diffDB(prev, next DBStructure) []logEntry {
+	entries := []logEntry{}
+	for id, chirp := range next.Chirps {
+		if old, ok := prev.Chirps[id]; !ok || !reflect.DeepEqual(old, chirp) {
+			entries = append(entries, putChirpEntry(chirp))
+		}
+	}
+	for id := range prev.Chirps {
+		if _, ok := next.Chirps[id]; !ok {
+			entries = append(entries, deleteChirpEntry(id))
+		}
+	}
+	for id, user := range next.Users {
+		if old, ok := prev.Users[id]; !ok || !reflect.DeepEqual(old, user) {
+			entries = append(entries, putUserEntry(user))
+		}
+	}
+	for id := range prev.Users {
+		if _, ok := next.Users[id]; !ok {
+			entries = append(entries, deleteUserEntry(id))
+		}
+	}
+	for id, session := range next.Sessions {
+		if old, ok := prev.Sessions[id]; !ok || !reflect.DeepEqual(old, session) {
+			entries = append(entries, putSessionEntry(session))
+		}
+	}
+	for id := range prev.Sessions {
+		if _, ok := next.Sessions[id]; !ok {
+			entries = append(entries, deleteSessionEntry(id))
+		}
+	}
+	for hash, token := range next.RefreshTokens {
+		if old, ok := prev.RefreshTokens[hash]; !ok || !reflect.DeepEqual(old, token) {
+			entries = append(entries, putRefreshTokenEntry(token))
+		}
+	}
+	for hash := range prev.RefreshTokens {
+		if _, ok := next.RefreshTokens[hash]; !ok {
+			entries = append(entries, deleteRefreshTokenEntry(hash))
+		}
+	}
+	for hash, reset := range next.PasswordResets {
+		if old, ok := prev.PasswordResets[hash]; !ok || !reflect.DeepEqual(old, reset) {
+			entries = append(entries, putPasswordResetEntry(reset))
+		}
+	}
+	for hash := range prev.PasswordResets {
+		if _, ok := next.PasswordResets[hash]; !ok {
+			entries = append(entries, deletePasswordResetEntry(hash))
+		}
+	}
+	for key, attempts := range next.LoginAttempts {
+		if old, ok := prev.LoginAttempts[key]; !ok || !reflect.DeepEqual(old, attempts) {
+			entries = append(entries, putLoginAttemptsEntry(attempts))
+		}
+	}
+	for key := range prev.LoginAttempts {
+		if _, ok := next.LoginAttempts[key]; !ok {
+			entries = append(entries, deleteLoginAttemptsEntry(key))
+		}
+	}
+	for hash, key := range next.APIKeys {
+		if old, ok := prev.APIKeys[hash]; !ok || !reflect.DeepEqual(old, key) {
+			entries = append(entries, putAPIKeyEntry(key))
+		}
+	}
+	for hash := range prev.APIKeys {
+		if _, ok := next.APIKeys[hash]; !ok {
+			entries = append(entries, deleteAPIKeyEntry(hash))
+		}
+	}
+	for table, seq := range next.Sequences {
+		if prev.Sequences[table] != seq {
+			entries = append(entries, setSequenceEntry(table, seq))
+		}
+	}
+	return entries
+}
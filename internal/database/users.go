@@ -2,40 +2,99 @@ package database
 
 import (
 	"errors"
+	"time"
 )
 
+// Role - grants access to parts of the api beyond a user's own data
+type Role string
+
+const (
+	// RoleUser - every account has it
+	RoleUser Role = "user"
+	// RoleModerator - can review flagged chirps
+	RoleModerator Role = "moderator"
+	// RoleAdmin - can use every admin endpoint and manage roles
+	RoleAdmin Role = "admin"
+)
+
+var ErrInvalidRole = errors.New("invalid role")
+
+// ParseRole - validates a role from outside the db
+func ParseRole(s string) (Role, error) {
+	switch role := Role(s); role {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return role, nil
+	default:
+		return "", ErrInvalidRole
+	}
+}
+
+// HasRole - whether the user has any of the given roles
+func (user User) HasRole(roles ...Role) bool {
+	for _, have := range user.Roles {
+		for _, want := range roles {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // struct used for storing user data
 type User struct {
-	ID    int    `json:"id"`
-	Email string `json:"email"`
+	ID int `json:"id"`
+	// opaque id safe to show publicly, empty unless the db issues public ids
+	PublicID string `json:"public_id,omitempty"`
+	Email    string `json:"email"`
+	// whether the user proved they own Email, new users start unverified
+	EmailVerified bool `json:"email_verified"`
 	// should store in hashed value
 	HashedPassword string `json:"hashed_password"`
 	// status for if is chirpy red member
 	IsChirpyRed bool `json:"is_chirpy_red"`
+	// what the user may do beyond managing their own data
+	Roles []Role `json:"roles"`
+	// access tokens issued before this are rejected, zero if never set
+	TokensValidAfter time.Time `json:"tokens_valid_after"`
+	// base32 totp secret, set by enrollment but only asked for once TOTPEnabled
+	TOTPSecret  string `json:"totp_secret"`
+	TOTPEnabled bool   `json:"totp_enabled"`
+	// time step of the last accepted totp code, so a code works only once
+	TOTPLastStep int64 `json:"totp_last_step"`
+	// hashes of the unused recovery codes
+	RecoveryCodes []string  `json:"recovery_codes"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
 
 var ErrAlreadyExists = errors.New("already exists")
 
 func (db *DB) CreateUser(email, hashedPassword string) (User, error) {
-	// check if user already exists in db
-	if _, err := db.GetUserByEmail(email); !errors.Is(err, ErrNotExist) {
-		return User{}, ErrAlreadyExists
-	}
-	// load db and check error
-	dbStructure, err := db.loadDB()
+	publicID, err := newPublicID(db.idFormat)
 	if err != nil {
 		return User{}, err
 	}
-	// simple id incremental value
-	id := len(dbStructure.Users) + 1
-	user := User{
-		ID:             id,
-		Email:          email,
-		HashedPassword: hashedPassword,
-	}
-	dbStructure.Users[id] = user
-
-	err = db.writeDB(dbStructure)
+	user := User{}
+	err = db.Update(func(dbStructure *DBStructure) error {
+		// check if user already exists in db, in the same transaction as the insert
+		if _, ok := dbStructure.userByEmail(email); ok {
+			return ErrAlreadyExists
+		}
+		id := dbStructure.nextID(seqUsers)
+		now := time.Now().UTC()
+		user = User{
+			ID:             id,
+			PublicID:       publicID,
+			Email:          email,
+			HashedPassword: hashedPassword,
+			Roles:          []Role{RoleUser},
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		}
+		dbStructure.Users[id] = user
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
@@ -44,51 +103,67 @@ func (db *DB) CreateUser(email, hashedPassword string) (User, error) {
 }
 
 func (db *DB) GetUser(id int) (User, error) {
-	dbStructure, err := db.loadDB()
+	user := User{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		// check if user exists
+		var ok bool
+		user, ok = dbStructure.Users[id]
+		if !ok {
+			// cant find user, return error
+			return ErrNotExist
+		}
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
-	// check if user exists
-	user, ok := dbStructure.Users[id]
-	if !ok {
-		// cant find user, return error
-		return User{}, ErrNotExist
-	}
 
 	return user, nil
 }
 
 func (db *DB) GetUserByEmail(email string) (User, error) {
-	dbStructure, err := db.loadDB()
+	user := User{}
+	err := db.View(func(dbStructure *DBStructure) error {
+		// check if user email exists
+		var ok bool
+		user, ok = dbStructure.userByEmail(email)
+		if !ok {
+			// can't find user, return error
+			return ErrNotExist
+		}
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
-	// check if user email exists
-	for _, user := range dbStructure.Users {
-		if user.Email == email {
-			return user, nil
-		}
-	}
-	// can't find user, return error
-	return User{}, ErrNotExist
+
+	return user, nil
 }
 
+// UpdateUser - replaces the email and password, a new email has to be verified again
 func (db *DB) UpdateUser(id int, email, hashedPassword string) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-	// check if user exists
-	user, ok := dbStructure.Users[id]
-	if !ok {
-		return User{}, ErrNotExist
-	}
-	// replace old user entry with new values
-	user.Email = email
-	user.HashedPassword = hashedPassword
-	dbStructure.Users[id] = user
-
-	err = db.writeDB(dbStructure)
+	user := User{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		// check if user exists
+		var ok bool
+		user, ok = dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+		// the email may only belong to one user
+		if other, ok := dbStructure.userByEmail(email); ok && other.ID != id {
+			return ErrAlreadyExists
+		}
+		if user.Email != email {
+			user.EmailVerified = false
+		}
+		// replace old user entry with new values
+		user.Email = email
+		user.HashedPassword = hashedPassword
+		user.UpdatedAt = time.Now().UTC()
+		dbStructure.Users[id] = user
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
@@ -97,23 +172,86 @@ func (db *DB) UpdateUser(id int, email, hashedPassword string) (User, error) {
 }
 
 func (db *DB) UpgradeChirpyRed(id int) (User, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
-	// check if user exists
-	user, ok := dbStructure.Users[id]
-	if !ok {
-		return User{}, ErrNotExist
-	}
-	// change the membership field to true
-	user.IsChirpyRed = true
-	dbStructure.Users[id] = user
+	return db.updateUser(id, func(user *User) error {
+		// change the membership field to true
+		user.IsChirpyRed = true
+		return nil
+	})
+}
+
+// SetUserRoles - replaces the roles of the user
+func (db *DB) SetUserRoles(id int, roles []Role) (User, error) {
+	return db.updateUser(id, func(user *User) error {
+		user.Roles = roles
+		return nil
+	})
+}
+
+// RehashPassword - replaces the password hash with a stronger hash of the same
+// password, ErrNotExist if the user is gone or changed their password since
+// oldHash was read. Not a change the user made, so UpdatedAt stays the same
+func (db *DB) RehashPassword(id int, oldHash, newHash string) error {
+	return db.Update(func(dbStructure *DBStructure) error {
+		user, ok := dbStructure.Users[id]
+		if !ok || user.HashedPassword != oldHash {
+			return ErrNotExist
+		}
+		user.HashedPassword = newHash
+		dbStructure.Users[id] = user
+		return nil
+	})
+}
+
+// VerifyUserEmail - marks the email of the user as verified, ErrNotExist if
+// the user doesn't exist or no longer has that email
+func (db *DB) VerifyUserEmail(id int, email string) (User, error) {
+	return db.updateUser(id, func(user *User) error {
+		if user.Email != email {
+			return ErrNotExist
+		}
+		user.EmailVerified = true
+		return nil
+	})
+}
 
-	err = db.writeDB(dbStructure)
+// InvalidateUserTokens - rejects every access token issued to the user so far
+func (db *DB) InvalidateUserTokens(id int) (User, error) {
+	return db.updateUser(id, func(user *User) error {
+		user.TokensValidAfter = time.Now().UTC()
+		return nil
+	})
+}
+
+// load the user, apply fn and store the result with a new updated_at, all in
+// one transaction
+func (db *DB) updateUser(id int, fn func(user *User) error) (User, error) {
+	user := User{}
+	err := db.Update(func(dbStructure *DBStructure) error {
+		// check if user exists
+		var ok bool
+		user, ok = dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+		if err := fn(&user); err != nil {
+			return err
+		}
+		user.UpdatedAt = time.Now().UTC()
+		dbStructure.Users[id] = user
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
 
 	return user, nil
 }
+
+func (dbStructure *DBStructure) userByEmail(email string) (User, bool) {
+	for _, user := range dbStructure.Users {
+		if user.Email == email {
+			return user, true
+		}
+	}
+	return User{}, false
+}
@@ -0,0 +1,379 @@
+package database
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// type of change recorded in the operation log
+type logOp string
+
+const (
+	opPutChirp            logOp = "put_chirp"
+	opDeleteChirp         logOp = "delete_chirp"
+	opPutUser             logOp = "put_user"
+	opDeleteUser          logOp = "delete_user"
+	opPutSession          logOp = "put_session"
+	opDeleteSession       logOp = "delete_session"
+	opPutRefreshToken     logOp = "put_refresh_token"
+	opDeleteRefreshToken  logOp = "delete_refresh_token"
+	opPutPasswordReset    logOp = "put_password_reset"
+	opDeletePasswordReset logOp = "delete_password_reset"
+	opPutLoginAttempts    logOp = "put_login_attempts"
+	opDeleteLoginAttempts logOp = "delete_login_attempts"
+	opPutAPIKey           logOp = "put_api_key"
+	opDeleteAPIKey        logOp = "delete_api_key"
+	opSetSequence         logOp = "set_sequence"
+	// revocations were replaced by sessions in schema version 5, entries
+	// logged by older versions are skipped
+	opPutRevocation    logOp = "put_revocation"
+	opDeleteRevocation logOp = "delete_revocation"
+)
+
+// single entry of the operation log, only the field matching Op is set
+type logEntry struct {
+	Op            logOp          `json:"op"`
+	ID            int            `json:"id,omitempty"`
+	Token         string         `json:"token,omitempty"`
+	Table         string         `json:"table,omitempty"`
+	Seq           int            `json:"seq,omitempty"`
+	Chirp         *Chirp         `json:"chirp,omitempty"`
+	User          *User          `json:"user,omitempty"`
+	Session       *Session       `json:"session,omitempty"`
+	RefreshToken  *RefreshToken  `json:"refresh_token,omitempty"`
+	PasswordReset *PasswordReset `json:"password_reset,omitempty"`
+	LoginAttempts *LoginAttempts `json:"login_attempts,omitempty"`
+	APIKey        *APIKey        `json:"api_key,omitempty"`
+}
+
+func putChirpEntry(chirp Chirp) logEntry {
+	return logEntry{Op: opPutChirp, Chirp: &chirp}
+}
+
+func deleteChirpEntry(id int) logEntry {
+	return logEntry{Op: opDeleteChirp, ID: id}
+}
+
+func putUserEntry(user User) logEntry {
+	return logEntry{Op: opPutUser, User: &user}
+}
+
+func deleteUserEntry(id int) logEntry {
+	return logEntry{Op: opDeleteUser, ID: id}
+}
+
+func putSessionEntry(session Session) logEntry {
+	return logEntry{Op: opPutSession, Session: &session}
+}
+
+func deleteSessionEntry(id int) logEntry {
+	return logEntry{Op: opDeleteSession, ID: id}
+}
+
+func putRefreshTokenEntry(token RefreshToken) logEntry {
+	return logEntry{Op: opPutRefreshToken, RefreshToken: &token}
+}
+
+func deleteRefreshTokenEntry(hash string) logEntry {
+	return logEntry{Op: opDeleteRefreshToken, Token: hash}
+}
+
+func putPasswordResetEntry(reset PasswordReset) logEntry {
+	return logEntry{Op: opPutPasswordReset, PasswordReset: &reset}
+}
+
+func deletePasswordResetEntry(hash string) logEntry {
+	return logEntry{Op: opDeletePasswordReset, Token: hash}
+}
+
+func putLoginAttemptsEntry(attempts LoginAttempts) logEntry {
+	return logEntry{Op: opPutLoginAttempts, LoginAttempts: &attempts}
+}
+
+func deleteLoginAttemptsEntry(key string) logEntry {
+	return logEntry{Op: opDeleteLoginAttempts, Token: key}
+}
+
+func putAPIKeyEntry(key APIKey) logEntry {
+	return logEntry{Op: opPutAPIKey, APIKey: &key}
+}
+
+func deleteAPIKeyEntry(hash string) logEntry {
+	return logEntry{Op: opDeleteAPIKey, Token: hash}
+}
+
+func setSequenceEntry(table string, seq int) logEntry {
+	return logEntry{Op: opSetSequence, Table: table, Seq: seq}
+}
+
+// apply the change onto the structure, entries only ever set or delete whole
+// records so replaying an entry that is already in the snapshot is harmless
+func (entry logEntry) apply(dbStructure *DBStructure) error {
+	switch entry.Op {
+	case opPutChirp:
+		if entry.Chirp == nil {
+			return errors.New("log entry missing chirp")
+		}
+		dbStructure.Chirps[entry.Chirp.ID] = *entry.Chirp
+	case opDeleteChirp:
+		delete(dbStructure.Chirps, entry.ID)
+	case opPutUser:
+		if entry.User == nil {
+			return errors.New("log entry missing user")
+		}
+		dbStructure.Users[entry.User.ID] = *entry.User
+	case opDeleteUser:
+		delete(dbStructure.Users, entry.ID)
+	case opPutSession:
+		if entry.Session == nil {
+			return errors.New("log entry missing session")
+		}
+		dbStructure.Sessions[entry.Session.ID] = *entry.Session
+	case opDeleteSession:
+		delete(dbStructure.Sessions, entry.ID)
+	case opPutRefreshToken:
+		if entry.RefreshToken == nil {
+			return errors.New("log entry missing refresh token")
+		}
+		dbStructure.RefreshTokens[entry.RefreshToken.Hash] = *entry.RefreshToken
+	case opDeleteRefreshToken:
+		delete(dbStructure.RefreshTokens, entry.Token)
+	case opPutPasswordReset:
+		if entry.PasswordReset == nil {
+			return errors.New("log entry missing password reset")
+		}
+		dbStructure.PasswordResets[entry.PasswordReset.Hash] = *entry.PasswordReset
+	case opDeletePasswordReset:
+		delete(dbStructure.PasswordResets, entry.Token)
+	case opPutLoginAttempts:
+		if entry.LoginAttempts == nil {
+			return errors.New("log entry missing login attempts")
+		}
+		dbStructure.LoginAttempts[entry.LoginAttempts.Key] = *entry.LoginAttempts
+	case opDeleteLoginAttempts:
+		delete(dbStructure.LoginAttempts, entry.Token)
+	case opPutAPIKey:
+		if entry.APIKey == nil {
+			return errors.New("log entry missing api key")
+		}
+		dbStructure.APIKeys[entry.APIKey.Hash] = *entry.APIKey
+	case opDeleteAPIKey:
+		delete(dbStructure.APIKeys, entry.Token)
+	case opPutRevocation, opDeleteRevocation:
+	case opSetSequence:
+		dbStructure.Sequences[entry.Table] = entry.Seq
+	default:
+		return fmt.Errorf("unknown log op %q", entry.Op)
+	}
+	return nil
+}
+
+// the operation log lives next to the snapshot, e.g. "database.json.log"
+func (db *DB) logPath() string {
+	return logPath(db.path)
+}
+
+func logPath(path string) string {
+	return path + ".log"
+}
+
+// one transaction of the operation log, written as a single line ending in a
+// newline, so a crash during the append leaves a torn last line that is
+// dropped whole instead of replaying part of the transaction
+type logRecord struct {
+	Entries []logEntry `json:"entries"`
+}
+
+// append the entries of a transaction to the operation log and fsync before
+// the snapshot is touched
+func (db *DB) appendLog(entries []logEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(db.logPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// the encoder ends the record with the newline that commits it
+	err = json.NewEncoder(f).Encode(logRecord{Entries: entries})
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// read back the entries of all committed transactions in the operation log
+func (db *DB) readLog() ([]logEntry, error) {
+	return readLogFile(db.logPath())
+}
+
+func readLogFile(path string) ([]logEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []logEntry{}
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		// a last line without its newline was torn by a crash during the
+		// append, its transaction never committed
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		record := logRecord{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, fmt.Errorf("corrupt operation log record: %w", err)
+		}
+		// logs from before transactions were framed hold one entry per line
+		if record.Entries == nil {
+			entry := logEntry{}
+			if err := json.Unmarshal(line, &entry); err != nil || entry.Op == "" {
+				return nil, errors.New("corrupt operation log record: no entries")
+			}
+			record.Entries = []logEntry{entry}
+		}
+		entries = append(entries, record.Entries...)
+	}
+}
+
+// empty the operation log once its entries are part of a durable snapshot
+func (db *DB) truncateLog() error {
+	return db.truncateLogTo(0)
+}
+
+// size of the operation log, where the next append starts
+func (db *DB) logSize() (int64, error) {
+	info, err := os.Stat(db.logPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// cut the operation log back to size and fsync, so the cut survives a crash
+func (db *DB) truncateLogTo(size int64) error {
+	f, err := os.OpenFile(db.logPath(), os.O_WRONLY, 0600)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// take entries appended from size on back out of the log after the change
+// they describe failed, otherwise it would be replayed on the next startup
+func (db *DB) rollbackLog(size int64, cause error) error {
+	err := db.truncateLogTo(size)
+	if err != nil {
+		return errors.Join(cause, fmt.Errorf("rolling back operation log: %w", err))
+	}
+	return cause
+}
+
+// replay any logged operations that did not make it into the snapshot onto
+// the cache, then checkpoint so the log starts empty, caller must hold mu
+func (db *DB) recover() error {
+	entries, err := db.readLog()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return db.truncateLog()
+	}
+	dbStructure := db.data.clone()
+	for _, entry := range entries {
+		if err := entry.apply(&dbStructure); err != nil {
+			return err
+		}
+	}
+	err = db.writeDB(dbStructure)
+	if err != nil {
+		return err
+	}
+	db.data = dbStructure
+	return nil
+}
+
+// errRenameNotSynced - writeFileAtomic replaced the file but couldn't fsync
+// the directory afterwards
+var errRenameNotSynced = errors.New("replaced file but couldn't sync its directory")
+
+// replace the file at path with dat, a reader only ever sees the old or the
+// new content: write to a temp file, fsync, rename over, fsync the directory
+func writeFileAtomic(path string, dat []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	// clean up the temp file if anything below fails
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(dat); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	// the new content is in place, only whether the rename survives a crash
+	// is unknown
+	if err := syncDir(dir); err != nil {
+		return fmt.Errorf("%w: %w", errRenameNotSynced, err)
+	}
+	return nil
+}
+
+// fsync a directory so a rename within it survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
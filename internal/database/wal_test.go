@@ -0,0 +1,211 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestRecoverReplaysLog(t *testing.T) {
+	db := newTestDB(t)
+	user, err := db.CreateUser("a@example.com", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a crash between logging a change and replacing the snapshot
+	chirp := Chirp{ID: 1, Body: "logged before the crash", AuthorID: user.ID, Status: ChirpStatusApproved}
+	err = db.appendLog([]logEntry{putChirpEntry(chirp), setSequenceEntry(seqChirps, 1), deleteUserEntry(user.ID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = NewDB(db.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	got, err := db.GetChirp(1)
+	if err != nil {
+		t.Fatalf("logged chirp was not replayed: %v", err)
+	}
+	if got.Body != chirp.Body {
+		t.Errorf("replayed chirp has body %q, want %q", got.Body, chirp.Body)
+	}
+	if _, err := db.GetUser(user.ID); !errors.Is(err, ErrNotExist) {
+		t.Errorf("logged delete was not replayed: %v", err)
+	}
+	// the snapshot holds the replayed changes and the log starts over
+	entries, err := db.readLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("log kept %d entries after recovery", len(entries))
+	}
+	next, err := db.CreateChirp("after recovery", 0, ChirpStatusApproved, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID != 2 {
+		t.Errorf("next chirp got id %d, want 2", next.ID)
+	}
+}
+
+func TestRecoverDropsTornEntry(t *testing.T) {
+	db := newTestDB(t)
+	err := db.appendLog([]logEntry{putChirpEntry(Chirp{ID: 1, Body: "complete", Status: ChirpStatusApproved})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the crash cut the second append short
+	f, err := os.OpenFile(db.logPath(), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"op":"put_chirp","chirp":{"id":2,"bo`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	db.Close()
+
+	db, err = NewDB(db.path)
+	if err != nil {
+		t.Fatalf("NewDB with a torn log: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.GetChirp(1); err != nil {
+		t.Errorf("complete entry was not replayed: %v", err)
+	}
+	if _, err := db.GetChirp(2); !errors.Is(err, ErrNotExist) {
+		t.Errorf("torn entry was replayed: %v", err)
+	}
+	info, err := os.Stat(db.logPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("log is %d bytes after recovery, want 0", info.Size())
+	}
+}
+
+func TestRecoverDropsUncommittedTransaction(t *testing.T) {
+	db := newTestDB(t)
+	err := db.appendLog([]logEntry{putChirpEntry(Chirp{ID: 1, Body: "committed", Status: ChirpStatusApproved}), setSequenceEntry(seqChirps, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the whole second transaction made it to disk except the newline that
+	// commits it, none of it may be replayed
+	dat, err := json.Marshal(logRecord{Entries: []logEntry{
+		putChirpEntry(Chirp{ID: 2, Body: "uncommitted", Status: ChirpStatusApproved}),
+		setSequenceEntry(seqChirps, 2),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(db.logPath(), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(dat); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	db.Close()
+
+	db, err = NewDB(db.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.GetChirp(1); err != nil {
+		t.Errorf("committed transaction was not replayed: %v", err)
+	}
+	if _, err := db.GetChirp(2); !errors.Is(err, ErrNotExist) {
+		t.Errorf("uncommitted transaction was replayed: %v", err)
+	}
+	// the sequence bump of the dropped transaction is gone with it
+	next, err := db.CreateChirp("next", 0, ChirpStatusApproved, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID != 2 {
+		t.Errorf("next chirp got id %d, want 2", next.ID)
+	}
+}
+
+func TestFailedSnapshotWriteRollsBackLog(t *testing.T) {
+	db := newTestDB(t)
+	// a directory in place of the snapshot makes the rename fail
+	if err := os.Remove(db.path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(db.path, "blocker"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := db.CreateUser("a@example.com", "hash")
+	if err == nil {
+		t.Fatal("CreateUser succeeded without a snapshot to write")
+	}
+	entries, err := db.readLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("log kept %d entries of the failed change", len(entries))
+	}
+	if _, err := db.GetUserByEmail("a@example.com"); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("failed change is in the cache: %v", err)
+	}
+}
+
+func TestMigrateRefusesPendingLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	old := []byte(`{"schema_version":8,"chirps":{},"users":{},"sessions":{},"refresh_tokens":{},"password_resets":{},"login_attempts":{},"sequences":{"chirps":0,"users":0,"sessions":0}}`)
+	if err := os.WriteFile(path, old, 0600); err != nil {
+		t.Fatal(err)
+	}
+	entry := `{"op":"put_chirp","chirp":{"id":1,"body":"logged by the old version"}}` + "\n"
+	if err := os.WriteFile(logPath(path), []byte(entry), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewDB(path)
+	if !errors.Is(err, ErrPendingLog) {
+		t.Fatalf("NewDB: got %v, want ErrPendingLog", err)
+	}
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dat) != string(old) {
+		t.Fatalf("snapshot was migrated: %s", dat)
+	}
+
+	// once the old version replayed and emptied the log it migrates
+	if err := os.Truncate(logPath(path), 0); err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.data.SchemaVersion != CurrentSchemaVersion() {
+		t.Fatalf("schema version %d, want %d", db.data.SchemaVersion, CurrentSchemaVersion())
+	}
+}
@@ -0,0 +1,75 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileMailer - writes every email to its own .eml file in a directory
+type FileMailer struct {
+	dir  string
+	from string
+	mu   *sync.Mutex
+	sent int
+}
+
+// NewFileMailer - writes emails from from into dir, creating it if missing
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &FileMailer{
+		dir:  dir,
+		from: from,
+		mu:   &sync.Mutex{},
+	}, nil
+}
+
+func (mailer *FileMailer) Send(msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	mailer.mu.Lock()
+	defer mailer.mu.Unlock()
+
+	now := time.Now()
+	mailer.sent++
+	// sorts by the time it was sent, the counter keeps names unique
+	name := fmt.Sprintf("%s-%d-%s.eml", now.UTC().Format("20060102T150405Z"), mailer.sent, safeFileName(msg.To))
+	return os.WriteFile(filepath.Join(mailer.dir, name), msg.bytes(mailer.from, now), 0o600)
+}
+
+func safeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '@':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+// LogMailer - prints emails to the server log instead of sending them
+type LogMailer struct{}
+
+func (LogMailer) Send(msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	log.Printf("Email to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// compile time checks that every mailer satisfies Mailer
+var (
+	_ Mailer = (*SMTPMailer)(nil)
+	_ Mailer = (*FileMailer)(nil)
+	_ Mailer = LogMailer{}
+)
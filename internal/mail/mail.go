@@ -0,0 +1,43 @@
+package mail
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Message - a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer - sends emails, SMTPMailer delivers them, FileMailer and LogMailer
+// keep them on the machine for local development
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// format the message as it is sent over smtp, lines end in \r\n
+func (msg Message) bytes(from string, date time.Time) []byte {
+	lines := []string{
+		"From: " + from,
+		"To: " + msg.To,
+		"Subject: " + msg.Subject,
+		"Date: " + date.Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+	}
+	lines = append(lines, strings.Split(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n")...)
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// header values can't span lines, or a recipient could add headers of their own
+func (msg Message) validate() error {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return fmt.Errorf("invalid header in message to %q", msg.To)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package mail
+
+import (
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SMTPMailer - delivers emails through an smtp server
+type SMTPMailer struct {
+	// host:port of the server
+	addr string
+	from string
+	// nil to send without logging in
+	auth smtp.Auth
+}
+
+// NewSMTPMailer - sends through the server at addr as from, logging in with
+// username and password if a username is given
+func NewSMTPMailer(addr, from, username, password string) (*SMTPMailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	mailer := &SMTPMailer{
+		addr: addr,
+		from: from,
+	}
+	if username != "" {
+		// only sent over tls, or to a server on localhost
+		mailer.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return mailer, nil
+}
+
+func (mailer *SMTPMailer) Send(msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	return smtp.SendMail(mailer.addr, mailer.auth, mailer.from, []string{msg.To}, msg.bytes(mailer.from, time.Now()))
+}
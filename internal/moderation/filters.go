@@ -0,0 +1,133 @@
+package moderation
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// Span - byte range of the original text that a filter matched
+type Span struct {
+	Start int
+	End   int
+}
+
+// Filter - finds the parts of a text that break a rule
+type Filter interface {
+	// Find returns the matched spans, tokens are the words of text
+	Find(text string, tokens []Token) []Span
+}
+
+// FilterBuilder - creates a Filter from its rule in the config file
+type FilterBuilder func(rule RuleConfig) (Filter, error)
+
+var (
+	buildersMu = &sync.RWMutex{}
+	// rule types usable in the config file, extended through RegisterFilter
+	builders = map[string]FilterBuilder{
+		"words": newWordFilter,
+		"regex": newRegexFilter,
+	}
+)
+
+// RegisterFilter - makes a new rule type available to config files
+func RegisterFilter(ruleType string, builder FilterBuilder) {
+	buildersMu.Lock()
+	defer buildersMu.Unlock()
+
+	builders[ruleType] = builder
+}
+
+func buildFilter(rule RuleConfig) (Filter, error) {
+	buildersMu.RLock()
+	builder, ok := builders[rule.Type]
+	buildersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown rule type %q", rule.Type)
+	}
+	return builder(rule)
+}
+
+// wordFilter - matches whole words or phrases after normalization
+type wordFilter struct {
+	// each entry is the normalized words of one banned word or phrase
+	phrases [][]string
+}
+
+func newWordFilter(rule RuleConfig) (Filter, error) {
+	filter := &wordFilter{}
+	for _, word := range rule.Words {
+		tokens := Tokenize(word)
+		if len(tokens) == 0 {
+			continue
+		}
+		phrase := make([]string, 0, len(tokens))
+		for _, token := range tokens {
+			phrase = append(phrase, token.Norm)
+		}
+		filter.phrases = append(filter.phrases, phrase)
+	}
+	if len(filter.phrases) == 0 {
+		return nil, errors.New("words rule has no words")
+	}
+	return filter, nil
+}
+
+func (filter *wordFilter) Find(text string, tokens []Token) []Span {
+	spans := []Span{}
+	for i := range tokens {
+		for _, phrase := range filter.phrases {
+			if phraseAt(tokens, i, phrase) {
+				spans = append(spans, Span{
+					Start: tokens[i].Start,
+					End:   tokens[i+len(phrase)-1].End,
+				})
+			}
+		}
+	}
+	return spans
+}
+
+func phraseAt(tokens []Token, i int, phrase []string) bool {
+	if i+len(phrase) > len(tokens) {
+		return false
+	}
+	for j, word := range phrase {
+		if tokens[i+j].Norm != word {
+			return false
+		}
+	}
+	return true
+}
+
+// regexFilter - matches a regular expression against the original text
+type regexFilter struct {
+	re *regexp.Regexp
+}
+
+func newRegexFilter(rule RuleConfig) (Filter, error) {
+	if rule.Pattern == "" {
+		return nil, errors.New("regex rule has no pattern")
+	}
+	pattern := rule.Pattern
+	if !rule.CaseSensitive {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &regexFilter{re: re}, nil
+}
+
+func (filter *regexFilter) Find(text string, tokens []Token) []Span {
+	spans := []Span{}
+	for _, loc := range filter.re.FindAllStringIndex(text, -1) {
+		if loc[0] == loc[1] {
+			continue
+		}
+		spans = append(spans, Span{Start: loc[0], End: loc[1]})
+	}
+	return spans
+}
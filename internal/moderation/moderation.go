@@ -0,0 +1,274 @@
+package moderation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Action - what happens to a chirp that breaks a rule
+type Action string
+
+const (
+	// ActionMask - replace the matched text with "****"
+	ActionMask Action = "mask"
+	// ActionReject - refuse the chirp
+	ActionReject Action = "reject"
+	// ActionFlag - accept the chirp but hold it for review
+	ActionFlag Action = "flag"
+)
+
+// text that replaces masked matches
+const maskText = "****"
+
+// Config - layout of the moderation rules file
+type Config struct {
+	Rules []RuleConfig `json:"rules"`
+}
+
+// RuleConfig - one rule of the config file, which fields are used depends on Type
+type RuleConfig struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Action Action `json:"action"`
+	// "words" rules: words or phrases matched after normalization
+	Words []string `json:"words,omitempty"`
+	// "regex" rules: pattern matched against the original text
+	Pattern       string `json:"pattern,omitempty"`
+	CaseSensitive bool   `json:"case_sensitive,omitempty"`
+}
+
+// rules used when no config file is given
+var DefaultConfig = Config{
+	Rules: []RuleConfig{
+		{
+			Name:   "profanity",
+			Type:   "words",
+			Action: ActionMask,
+			Words:  []string{"kerfuffle", "sharbert", "fornax"},
+		},
+	},
+}
+
+// Rule - a filter and what to do when it matches
+type Rule struct {
+	Name   string
+	Action Action
+	Filter Filter
+}
+
+// Match - a part of the text that broke a rule
+type Match struct {
+	Rule   string `json:"rule"`
+	Action Action `json:"action"`
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+}
+
+// Result - outcome of moderating a text
+type Result struct {
+	// text with every masked match replaced
+	Text     string
+	Rejected bool
+	Flagged  bool
+	Matches  []Match
+}
+
+// Moderator - applies the current rules, safe for concurrent use and able to
+// pick up changes to its config file without a restart
+type Moderator struct {
+	mu    *sync.RWMutex
+	rules []Rule
+	// config file the rules were loaded from, empty for DefaultConfig
+	path    string
+	modTime time.Time
+}
+
+// NewModerator - loads rules from the json file at path, or DefaultConfig if path is empty
+func NewModerator(path string) (*Moderator, error) {
+	moderator := &Moderator{
+		mu:   &sync.RWMutex{},
+		path: path,
+	}
+	if path == "" {
+		rules, err := BuildRules(DefaultConfig)
+		if err != nil {
+			return nil, err
+		}
+		moderator.rules = rules
+		return moderator, nil
+	}
+	err := moderator.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return moderator, nil
+}
+
+// BuildRules - turns a config into rules, failing on the first invalid one
+func BuildRules(cfg Config) ([]Rule, error) {
+	rules := make([]Rule, 0, len(cfg.Rules))
+	for i, ruleCfg := range cfg.Rules {
+		name := ruleCfg.Name
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i+1)
+		}
+		switch ruleCfg.Action {
+		case ActionMask, ActionReject, ActionFlag:
+		default:
+			return nil, fmt.Errorf("%s: unknown action %q", name, ruleCfg.Action)
+		}
+		filter, err := buildFilter(ruleCfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		rules = append(rules, Rule{
+			Name:   name,
+			Action: ruleCfg.Action,
+			Filter: filter,
+		})
+	}
+	return rules, nil
+}
+
+// SetRules - replaces the rules in use
+func (moderator *Moderator) SetRules(rules []Rule) {
+	moderator.mu.Lock()
+	defer moderator.mu.Unlock()
+
+	moderator.rules = rules
+}
+
+// Reload - reads the config file again, the old rules stay in place if it is invalid
+func (moderator *Moderator) Reload() error {
+	if moderator.path == "" {
+		return errors.New("moderator has no config file")
+	}
+	info, err := os.Stat(moderator.path)
+	if err != nil {
+		return err
+	}
+	dat, err := os.ReadFile(moderator.path)
+	if err != nil {
+		return err
+	}
+	cfg := Config{}
+	err = json.Unmarshal(dat, &cfg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", moderator.path, err)
+	}
+	rules, err := BuildRules(cfg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", moderator.path, err)
+	}
+
+	moderator.mu.Lock()
+	defer moderator.mu.Unlock()
+	moderator.rules = rules
+	moderator.modTime = info.ModTime()
+	return nil
+}
+
+// Watch - reloads the config file whenever its modification time changes,
+// checking every interval until stop is closed, reload errors go to onError
+func (moderator *Moderator) Watch(interval time.Duration, stop <-chan struct{}, onError func(error)) {
+	if moderator.path == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		// last version seen, a broken file is reported once rather than every tick
+		moderator.mu.RLock()
+		lastSeen := moderator.modTime
+		moderator.mu.RUnlock()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			info, err := os.Stat(moderator.path)
+			if err != nil {
+				onError(err)
+				continue
+			}
+			if info.ModTime().Equal(lastSeen) {
+				continue
+			}
+			lastSeen = info.ModTime()
+			err = moderator.Reload()
+			if err != nil {
+				onError(err)
+			}
+		}
+	}()
+}
+
+// Moderate - checks text against every rule
+func (moderator *Moderator) Moderate(text string) Result {
+	moderator.mu.RLock()
+	rules := moderator.rules
+	moderator.mu.RUnlock()
+
+	tokens := Tokenize(text)
+	result := Result{Text: text, Matches: []Match{}}
+	masks := []Span{}
+	for _, rule := range rules {
+		for _, span := range rule.Filter.Find(text, tokens) {
+			result.Matches = append(result.Matches, Match{
+				Rule:   rule.Name,
+				Action: rule.Action,
+				Start:  span.Start,
+				End:    span.End,
+			})
+			switch rule.Action {
+			case ActionReject:
+				result.Rejected = true
+			case ActionFlag:
+				result.Flagged = true
+			case ActionMask:
+				masks = append(masks, span)
+			}
+		}
+	}
+	result.Text = applyMasks(text, masks)
+	return result
+}
+
+// replace every span with maskText, overlapping spans are masked once
+func applyMasks(text string, spans []Span) string {
+	if len(spans) == 0 {
+		return text
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].Start < spans[j].Start
+	})
+	// merge overlapping spans so each part of the text is masked at most once
+	merged := []Span{spans[0]}
+	for _, span := range spans[1:] {
+		prev := &merged[len(merged)-1]
+		if span.Start < prev.End {
+			if span.End > prev.End {
+				prev.End = span.End
+			}
+			continue
+		}
+		merged = append(merged, span)
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, span := range merged {
+		b.WriteString(text[last:span.Start])
+		b.WriteString(maskText)
+		last = span.End
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
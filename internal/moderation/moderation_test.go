@@ -0,0 +1,156 @@
+package moderation
+
+import (
+	"reflect"
+	"testing"
+)
+
+// moderator with the built in rules
+func newDefaultModerator(t *testing.T) *Moderator {
+	t.Helper()
+	moderator, err := NewModerator("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return moderator
+}
+
+func newTestModerator(t *testing.T, rules ...RuleConfig) *Moderator {
+	t.Helper()
+	built, err := BuildRules(Config{Rules: rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+	moderator := newDefaultModerator(t)
+	moderator.SetRules(built)
+	return moderator
+}
+
+func TestModerateMasking(t *testing.T) {
+	moderator := newDefaultModerator(t)
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"clean", "I had something interesting for breakfast", "I had something interesting for breakfast"},
+		{"one word", "I hear Mastodon is better than Chirpy. sharbert I need to migrate", "I hear Mastodon is better than Chirpy. **** I need to migrate"},
+		{"case", "I really need a KERFUFFLE to go to bed sooner, Fornax !", "I really need a **** to go to bed sooner, **** !"},
+		{"punctuation", "Kerfuffle! what a kerfuffle.", "****! what a ****."},
+		{"adjacent words", "kerfuffle fornax sharbert", "**** **** ****"},
+		{"inside a word", "kerfuffles and sharberts", "kerfuffles and sharberts"},
+		{"newlines", "line one\nfornax\nline three", "line one\n****\nline three"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := moderator.Moderate(tt.text)
+			if result.Text != tt.want {
+				t.Errorf("Moderate(%q).Text = %q, want %q", tt.text, result.Text, tt.want)
+			}
+			if result.Rejected || result.Flagged {
+				t.Errorf("Moderate(%q) rejected or flagged, masking rules only mask", tt.text)
+			}
+		})
+	}
+}
+
+func TestModerateUnicode(t *testing.T) {
+	moderator := newDefaultModerator(t)
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"accents", "what a kérfüfflé", "what a ****"},
+		{"fullwidth", "ｆｏｒｎａｘ again", "**** again"},
+		{"combining marks", "sharbért", "****"},
+		// masks are placed by byte offsets, text around them stays intact
+		{"multibyte neighbours", "日本 fornax 日本", "日本 **** 日本"},
+		{"emoji", "🙂fornax🙂", "🙂****🙂"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := moderator.Moderate(tt.text).Text; got != tt.want {
+				t.Errorf("Moderate(%q).Text = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModerateOverlappingMatches(t *testing.T) {
+	moderator := newTestModerator(t,
+		RuleConfig{Name: "words", Type: "words", Action: ActionMask, Words: []string{"bad", "bad word", "word here"}},
+		RuleConfig{Name: "digits", Type: "regex", Action: ActionMask, Pattern: `\d{3}`},
+		RuleConfig{Name: "ab", Type: "regex", Action: ActionMask, Pattern: `ab`},
+		RuleConfig{Name: "cd", Type: "regex", Action: ActionMask, Pattern: `cd`},
+	)
+	tests := []struct {
+		name        string
+		text        string
+		want        string
+		wantMatches int
+	}{
+		// "bad" sits inside "bad word", which overlaps "word here"
+		{"nested and chained phrases", "a bad word here", "a ****", 3},
+		{"regex over a phrase", "bad 123", "**** ****", 2},
+		// spans that only touch are masked separately
+		{"adjacent regex matches", "1234567", "********7", 2},
+		{"adjacent rules", "abcd", "********", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := moderator.Moderate(tt.text)
+			if result.Text != tt.want {
+				t.Errorf("Moderate(%q).Text = %q, want %q", tt.text, result.Text, tt.want)
+			}
+			if len(result.Matches) != tt.wantMatches {
+				t.Errorf("Moderate(%q) has %d matches, want %d: %+v", tt.text, len(result.Matches), tt.wantMatches, result.Matches)
+			}
+		})
+	}
+}
+
+func TestModerateActions(t *testing.T) {
+	moderator := newTestModerator(t,
+		RuleConfig{Name: "mask", Type: "words", Action: ActionMask, Words: []string{"fornax"}},
+		RuleConfig{Name: "flag", Type: "words", Action: ActionFlag, Words: []string{"buy now"}},
+		RuleConfig{Name: "reject", Type: "regex", Action: ActionReject, Pattern: `https?://`},
+	)
+	result := moderator.Moderate("fornax, buy now at http://example.com")
+	if result.Text != "****, buy now at http://example.com" {
+		t.Errorf("Text = %q, only masking rules change the text", result.Text)
+	}
+	if !result.Flagged || !result.Rejected {
+		t.Errorf("Flagged = %t, Rejected = %t, want both", result.Flagged, result.Rejected)
+	}
+	want := []Match{
+		{Rule: "mask", Action: ActionMask, Start: 0, End: 6},
+		{Rule: "flag", Action: ActionFlag, Start: 8, End: 15},
+		{Rule: "reject", Action: ActionReject, Start: 19, End: 26},
+	}
+	if !reflect.DeepEqual(result.Matches, want) {
+		t.Errorf("Matches = %+v, want %+v", result.Matches, want)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []Token
+	}{
+		{"", []Token{}},
+		{"Hi, there", []Token{
+			{Text: "Hi", Norm: "hi", Start: 0, End: 2},
+			{Text: "there", Norm: "there", Start: 4, End: 9},
+		}},
+		{"Ｆórnax!", []Token{
+			// fullwidth letter and accented letter take 3 and 2 bytes
+			{Text: "Ｆórnax", Norm: "fornax", Start: 0, End: 9},
+		}},
+	}
+	for _, tt := range tests {
+		if got := Tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package moderation
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Token - a word of the text and where it sits in the original string
+type Token struct {
+	// word as written in the text
+	Text string
+	// word after unicode normalization, used for matching
+	Norm string
+	// byte offsets of the word in the original text
+	Start int
+	End   int
+}
+
+// Tokenize - split text into words, anything that isn't a letter, digit or
+// combining mark ends a word, so "Kerfuffle!" and "kerfuffle\n" both give
+// the word "kerfuffle"
+func Tokenize(text string) []Token {
+	tokens := []Token{}
+	start := -1
+	for i, r := range text {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, newToken(text, start, i))
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, newToken(text, start, len(text)))
+	}
+	return tokens
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+func newToken(text string, start, end int) Token {
+	return Token{
+		Text:  text[start:end],
+		Norm:  Normalize(text[start:end]),
+		Start: start,
+		End:   end,
+	}
+}
+
+// Normalize - fold a word to the form rules are matched in: compatibility
+// decomposition turns lookalikes such as fullwidth letters into plain ones,
+// accents are dropped and the result is lower cased, "Ｆórnax" gives "fornax"
+func Normalize(word string) string {
+	decomposed := norm.NFKD.String(word)
+	var b strings.Builder
+	for _, r := range decomposed {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Policy - how many requests a client may make, the bucket holds Limit
+// requests and refills completely over Window
+type Policy struct {
+	// 0 turns limiting off
+	Limit  int      `json:"limit"`
+	Window Duration `json:"window"`
+	// bucket size for Chirpy Red members, Limit if not set
+	RedLimit int `json:"red_limit,omitempty"`
+}
+
+// LimitFor - bucket size for a client, red for Chirpy Red members
+func (policy Policy) LimitFor(red bool) int {
+	if red && policy.RedLimit > 0 {
+		return policy.RedLimit
+	}
+	return policy.Limit
+}
+
+func (policy Policy) validate() error {
+	if policy.Limit < 0 || policy.RedLimit < 0 {
+		return errors.New("limits can't be negative")
+	}
+	if policy.Limit > 0 && policy.Window <= 0 {
+		return errors.New("window must be positive")
+	}
+	return nil
+}
+
+// Config - layout of the rate limits file
+type Config struct {
+	// used for every route without its own policy
+	Default Policy `json:"default"`
+	// by method and chi route pattern, e.g. "POST /api/chirps" or
+	// "GET /api/chirps/{chirpID}"
+	Routes map[string]Policy `json:"routes"`
+}
+
+// limits used when no config file is given
+var DefaultConfig = Config{
+	Default: Policy{Limit: 120, Window: Duration(time.Minute), RedLimit: 240},
+	Routes: map[string]Policy{
+		"POST /api/chirps": {Limit: 10, Window: Duration(time.Minute), RedLimit: 30},
+		"POST /api/users":  {Limit: 5, Window: Duration(time.Hour)},
+		"POST /api/login":  {Limit: 10, Window: Duration(time.Minute)},
+		// six digit codes are only safe from guessing when slowed down
+		"POST /api/login/2fa":         {Limit: 10, Window: Duration(time.Minute)},
+		"POST /api/users/2fa/confirm": {Limit: 10, Window: Duration(time.Minute)},
+		// every resend is an email going out
+		"POST /api/users/verify/resend": {Limit: 3, Window: Duration(time.Hour)},
+		"POST /api/password/forgot":     {Limit: 5, Window: Duration(time.Hour)},
+		"POST /api/password/reset":      {Limit: 10, Window: Duration(time.Hour)},
+		// keys are long lived, nobody needs to make many
+		"POST /api/keys": {Limit: 10, Window: Duration(time.Hour)},
+		// polka retries failed deliveries, it shouldn't be turned away
+		"POST /api/polka/webhooks": {Limit: 0},
+	},
+}
+
+// LoadConfig - reads the json file at path, or DefaultConfig if path is empty
+func LoadConfig(path string) (Config, error) {
+	if path == "" {
+		return DefaultConfig, nil
+	}
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg := Config{}
+	err = json.Unmarshal(dat, &cfg)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := cfg.Default.validate(); err != nil {
+		return Config{}, fmt.Errorf("%s: default: %w", path, err)
+	}
+	for route, policy := range cfg.Routes {
+		if err := policy.validate(); err != nil {
+			return Config{}, fmt.Errorf("%s: %s: %w", path, route, err)
+		}
+	}
+	return cfg, nil
+}
+
+// Policy - the route, method and pattern as in Routes, and its policy. Routes
+// without their own policy use Default, still with buckets of their own
+func (cfg Config) Policy(method, pattern string) (string, Policy) {
+	route := method + " " + pattern
+	if policy, ok := cfg.Routes[route]; ok {
+		return route, policy
+	}
+	return route, cfg.Default
+}
+
+// Duration - time.Duration written as a string like "1m" or "1h30m" in json
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(dat []byte) error {
+	var s string
+	err := json.Unmarshal(dat, &s)
+	if err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
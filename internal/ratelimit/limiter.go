@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// Decision - outcome of taking a request from a bucket
+type Decision struct {
+	Allowed bool
+	// bucket size and requests left in it after this one
+	Limit     int
+	Remaining int
+	// until the bucket is full again
+	Reset time.Duration
+	// until the next request is allowed, 0 if this one was
+	RetryAfter time.Duration
+}
+
+// Limiter - token buckets by key, kept in memory and bounded in size
+type Limiter struct {
+	mu      *sync.Mutex
+	maxKeys int
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// when it refills completely, from then on it can be dropped
+	full time.Time
+}
+
+// NewLimiter - creates a limiter tracking at most maxKeys buckets
+func NewLimiter(maxKeys int) *Limiter {
+	return &Limiter{
+		mu:      &sync.Mutex{},
+		maxKeys: maxKeys,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Allow - takes a request from the bucket of key, which holds limit requests
+// and refills completely over window
+func (limiter *Limiter) Allow(key string, limit int, window time.Duration) Decision {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	return limiter.allowLocked(key, limit, window, time.Now())
+}
+
+func (limiter *Limiter) allowLocked(key string, limit int, window time.Duration, now time.Time) Decision {
+	// requests regained per second
+	rate := float64(limit) / window.Seconds()
+	b, ok := limiter.buckets[key]
+	if !ok {
+		limiter.makeRoomLocked(now)
+		b = &bucket{tokens: float64(limit), updated: now}
+		limiter.buckets[key] = b
+	}
+	b.refill(now, limit, rate)
+
+	decision := Decision{Limit: limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+	decision.Remaining = int(math.Floor(b.tokens))
+	decision.Reset = seconds((float64(limit) - b.tokens) / rate)
+	b.full = now.Add(decision.Reset)
+	return decision
+}
+
+func (b *bucket) refill(now time.Time, limit int, rate float64) {
+	elapsed := now.Sub(b.updated).Seconds()
+	b.tokens = math.Min(float64(limit), b.tokens+elapsed*rate)
+	b.updated = now
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Prune - drops buckets that refilled completely, they hold nothing a new
+// bucket wouldn't
+func (limiter *Limiter) Prune() {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	limiter.pruneLocked(time.Now())
+}
+
+func (limiter *Limiter) pruneLocked(now time.Time) {
+	for key, b := range limiter.buckets {
+		if !now.Before(b.full) {
+			delete(limiter.buckets, key)
+		}
+	}
+}
+
+// make room for one more bucket, dropping an arbitrary one if pruning didn't
+func (limiter *Limiter) makeRoomLocked(now time.Time) {
+	if len(limiter.buckets) < limiter.maxKeys {
+		return
+	}
+	limiter.pruneLocked(now)
+	for key := range limiter.buckets {
+		if len(limiter.buckets) < limiter.maxKeys {
+			return
+		}
+		delete(limiter.buckets, key)
+		log.Printf("Rate limiter is full, dropped bucket %s", key)
+	}
+}
+
+// Len - number of buckets currently held
+func (limiter *Limiter) Len() int {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	return len(limiter.buckets)
+}
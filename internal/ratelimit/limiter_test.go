@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestLimiterBurst(t *testing.T) {
+	limiter := NewLimiter(10)
+	for i := 0; i < 3; i++ {
+		decision := limiter.allowLocked("a", 3, time.Minute, start)
+		if !decision.Allowed {
+			t.Fatalf("request %d refused, the bucket starts full", i)
+		}
+		if decision.Remaining != 2-i {
+			t.Errorf("request %d: remaining %d, want %d", i, decision.Remaining, 2-i)
+		}
+	}
+	if decision := limiter.allowLocked("a", 3, time.Minute, start); decision.Allowed {
+		t.Error("request past the burst allowed")
+	}
+	// buckets are per key
+	if decision := limiter.allowLocked("b", 3, time.Minute, start); !decision.Allowed {
+		t.Error("another key shares the bucket")
+	}
+}
+
+func TestLimiterRefill(t *testing.T) {
+	limiter := NewLimiter(10)
+	for i := 0; i < 3; i++ {
+		limiter.allowLocked("a", 3, time.Minute, start)
+	}
+	// a request comes back every 20 seconds
+	if decision := limiter.allowLocked("a", 3, time.Minute, start.Add(19*time.Second)); decision.Allowed {
+		t.Error("allowed before a request refilled")
+	}
+	decision := limiter.allowLocked("a", 3, time.Minute, start.Add(20*time.Second))
+	if !decision.Allowed {
+		t.Fatal("refused after a request refilled")
+	}
+	if decision.Remaining != 0 {
+		t.Errorf("remaining %d, want 0", decision.Remaining)
+	}
+	// refilling stops at the limit
+	decision = limiter.allowLocked("a", 3, time.Minute, start.Add(time.Hour))
+	if decision.Remaining != 2 {
+		t.Errorf("remaining after a long wait %d, want 2", decision.Remaining)
+	}
+}
+
+func TestLimiterRetryAfter(t *testing.T) {
+	limiter := NewLimiter(10)
+	for i := 0; i < 3; i++ {
+		limiter.allowLocked("a", 3, time.Minute, start)
+	}
+	tests := []struct {
+		after time.Duration
+		want  time.Duration
+	}{
+		{0, 20 * time.Second},
+		{5 * time.Second, 15 * time.Second},
+		{15 * time.Second, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		decision := limiter.allowLocked("a", 3, time.Minute, start.Add(tt.after))
+		if decision.Allowed {
+			t.Fatalf("after %s: allowed, want refused", tt.after)
+		}
+		if diff := decision.RetryAfter - tt.want; diff > time.Millisecond || diff < -time.Millisecond {
+			t.Errorf("after %s: retry after %s, want %s", tt.after, decision.RetryAfter, tt.want)
+		}
+		if diff := decision.Reset - time.Minute + tt.after; diff > time.Millisecond || diff < -time.Millisecond {
+			t.Errorf("after %s: reset %s, want %s", tt.after, decision.Reset, time.Minute-tt.after)
+		}
+	}
+}
+
+func TestLimiterPrune(t *testing.T) {
+	limiter := NewLimiter(10)
+	limiter.allowLocked("a", 3, time.Minute, start)
+	for i := 0; i < 3; i++ {
+		limiter.allowLocked("b", 3, time.Minute, start)
+	}
+	// a took one request, refilled after 20 seconds; b took all three
+	limiter.pruneLocked(start.Add(30 * time.Second))
+	if _, ok := limiter.buckets["a"]; ok {
+		t.Error("full bucket kept")
+	}
+	if _, ok := limiter.buckets["b"]; !ok {
+		t.Error("bucket dropped before it refilled")
+	}
+	limiter.pruneLocked(start.Add(time.Minute))
+	if limiter.Len() != 0 {
+		t.Errorf("%d buckets left after every one refilled", limiter.Len())
+	}
+}
+
+func TestLimiterMakesRoom(t *testing.T) {
+	limiter := NewLimiter(2)
+	limiter.allowLocked("a", 3, time.Minute, start)
+	limiter.allowLocked("b", 3, time.Minute, start)
+	limiter.allowLocked("b", 3, time.Minute, start)
+	// a refilled by now and b didn't, a's bucket goes to c
+	limiter.allowLocked("c", 3, time.Minute, start.Add(25*time.Second))
+	if limiter.Len() != 2 {
+		t.Fatalf("%d buckets, want 2", limiter.Len())
+	}
+	if _, ok := limiter.buckets["a"]; ok {
+		t.Error("refilled bucket kept over a new one")
+	}
+	if _, ok := limiter.buckets["b"]; !ok {
+		t.Error("bucket dropped before it refilled")
+	}
+}
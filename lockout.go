@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/yuheng-liu/chirpy/internal/auth"
+	"github.com/yuheng-liu/chirpy/internal/database"
+)
+
+// where failed logins are counted, LOGIN_ATTEMPTS_STORE is "memory" (default)
+// for a single node or "database" to share them between nodes and restarts
+func attemptStoreFromEnv(db database.Store) (auth.AttemptStore, error) {
+	switch store := os.Getenv("LOGIN_ATTEMPTS_STORE"); store {
+	case "", "memory":
+		return auth.NewMemoryAttempts(100000), nil
+	case "database":
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unknown LOGIN_ATTEMPTS_STORE %q", store)
+	}
+}
+
+// tell the client it is locked out and when to try again, in whole seconds
+func respondLockedOut(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts, try again later")
+}
+
+// drop failure streaks that no longer lock anything every interval
+func pruneLoginAttempts(lockout *auth.Lockout, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		removed, err := lockout.Prune()
+		if err != nil {
+			log.Printf("Couldn't prune login attempts: %s", err)
+			continue
+		}
+		if removed > 0 {
+			log.Printf("Pruned %d login attempt records", removed)
+		}
+	}
+}
@@ -1,21 +1,40 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/joho/godotenv"
+	"github.com/yuheng-liu/chirpy/internal/auth"
 	"github.com/yuheng-liu/chirpy/internal/database"
+	"github.com/yuheng-liu/chirpy/internal/mail"
+	"github.com/yuheng-liu/chirpy/internal/moderation"
+	"github.com/yuheng-liu/chirpy/internal/ratelimit"
 )
 
 type apiConfig struct {
 	fileserverHits int
-	DB             *database.DB
-	jwtSecret      string
+	DB             database.Store
+	jwtKeys        *auth.Keys
 	polkaKey       string
+	moderator      *moderation.Moderator
+	denylist       *auth.Denylist
+	authn          *auth.Authenticator
+	lifetimes      tokenLifetimes
+	lockout        *auth.Lockout
+	rateLimits     ratelimit.Config
+	limiter        *ratelimit.Limiter
+	mailer         mail.Mailer
+	passwordPolicy *auth.PasswordPolicy
+	passwords      *auth.Passwords
+	// where clients reach the server, used in links sent by email
+	publicURL string
 }
 
 func main() {
@@ -24,18 +43,39 @@ func main() {
 
 	// by default, godotenv will look for a file named .env in the current directory
 	godotenv.Load()
-	// retrieve the env value for jwt secret
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	// "chirpy migrate" upgrades the database file instead of starting the server
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := runMigrate(os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	// "chirpy roles" shows or changes a user's roles, for granting admin access
+	if len(os.Args) > 1 && os.Args[1] == "roles" {
+		err := runRoles(os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	// load the keys jwts are signed and verified with
+	jwtKeys, err := jwtKeysFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// how long access tokens and sessions last
+	lifetimes, err := tokenLifetimesFromEnv()
+	if err != nil {
+		log.Fatal(err)
 	}
 	// retrieve the env value for authorization key
 	polkaKey := os.Getenv("POLKA_KEY")
 	if polkaKey == "" {
 		log.Fatal("POLKA_KEY environment variable is not set")
 	}
-	// creates a new .json db with file name "database.json"
-	db, err := database.NewDB("database.json")
+	// open the storage backend, defaults to a .json db with file name "database.json"
+	db, err := database.NewStore(dbConfigFromEnv())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -50,40 +90,146 @@ func main() {
 			log.Fatal(err)
 		}
 	}
+	// load chirp moderation rules, built in defaults unless a rules file is given
+	moderator, err := moderation.NewModerator(os.Getenv("MODERATION_RULES"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// pick up edits to the rules file without a restart
+	moderator.Watch(5*time.Second, nil, func(err error) {
+		log.Printf("Couldn't reload moderation rules: %s", err)
+	})
+	// drop ended sessions and their refresh tokens in the background
+	go pruneSessions(db, time.Hour)
+	go prunePasswordResets(db, time.Hour)
+	// access tokens revoked before they expire, entries go once the tokens would have
+	denylist := auth.NewDenylist(100000)
+	go pruneDenylist(denylist, time.Minute)
+	// failed logins per account and address, locked out with backoff
+	attempts, err := attemptStoreFromEnv(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lockout := auth.NewLockout(attempts, auth.DefaultAccountPolicy, auth.DefaultAddressPolicy)
+	go pruneLoginAttempts(lockout, time.Hour)
+	// request limits per route, built in defaults unless a limits file is given
+	rateLimits, err := ratelimit.LoadConfig(os.Getenv("RATE_LIMITS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	limiter := ratelimit.NewLimiter(100000)
+	go pruneRateLimiter(limiter, time.Minute)
+	// rules new passwords have to follow
+	passwordPolicy, err := passwordPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// hashes new passwords and checks stored ones
+	passwords, err := passwordsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// sends verification emails
+	mailer, err := mailerFromEnv(*dbg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	publicURL := os.Getenv("PUBLIC_URL")
+	if publicURL == "" {
+		publicURL = "http://localhost:" + port
+	}
 	// init apiConfig struct
 	apiCfg := apiConfig{
 		fileserverHits: 0,
 		DB:             db,
-		jwtSecret:      jwtSecret,
+		jwtKeys:        jwtKeys,
 		polkaKey:       polkaKey,
+		moderator:      moderator,
+		denylist:       denylist,
+		lifetimes:      lifetimes,
+		lockout:        lockout,
+		rateLimits:     rateLimits,
+		limiter:        limiter,
+		mailer:         mailer,
+		passwordPolicy: passwordPolicy,
+		passwords:      passwords,
+		publicURL:      publicURL,
+		// validates access tokens and loads their user for the routes below
+		authn: auth.NewAuthenticator(jwtKeys, denylist, db, respondWithError),
 	}
 
 	router := chi.NewRouter()
+	// every request counts against the limit of its route, per address until
+	// the request is authenticated and per user after
+	limitAddress := apiCfg.middlewareRateLimitAddress
+	required := func(next http.Handler) http.Handler {
+		return apiCfg.authn.Required(apiCfg.middlewareRateLimitUser(next))
+	}
+	optional := func(next http.Handler) http.Handler {
+		return apiCfg.authn.Optional(apiCfg.middlewareRateLimitUser(next))
+	}
 	fsHandler := apiCfg.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot))))
-	router.Handle("/app", fsHandler)
-	router.Handle("/app/*", fsHandler)
+	router.With(limitAddress).Handle("/app", fsHandler)
+	router.With(limitAddress).Handle("/app/*", fsHandler)
+	// public keys for services verifying chirpy tokens
+	router.With(limitAddress).Get("/.well-known/jwks.json", apiCfg.handlerJWKS)
 
 	apiRouter := chi.NewRouter()
 	// api common
-	apiRouter.Get("/healthz", handlerReadiness)
-	apiRouter.Get("/reset", apiCfg.handlerReset)
-	// chirps
-	apiRouter.Post("/chirps", apiCfg.handlerChirpsCreate)
-	apiRouter.Get("/chirps", apiCfg.handlerChirpsRetrieve)
-	apiRouter.Get("/chirps/{chirpID}", apiCfg.handlerChirpsGet)
-	apiRouter.Delete("/chirps/{chirpID}", apiCfg.handlerChirpsDelete)
+	apiRouter.With(limitAddress).Get("/healthz", handlerReadiness)
+	apiRouter.With(required, apiCfg.authn.RequireRole(database.RoleAdmin)).Get("/reset", apiCfg.handlerReset)
+	// chirps, reading works without logging in but authors also see their own pending chirps,
+	// bots use api keys with the matching scope
+	chirpsRead := apiCfg.authn.AllowAPIKeys(database.ScopeChirpsRead)
+	chirpsWrite := apiCfg.authn.AllowAPIKeys(database.ScopeChirpsWrite)
+	apiRouter.With(chirpsWrite, required, apiCfg.authn.RequireVerifiedEmail).Post("/chirps", apiCfg.handlerChirpsCreate)
+	apiRouter.With(chirpsRead, optional).Get("/chirps", apiCfg.handlerChirpsRetrieve)
+	apiRouter.With(chirpsRead, optional).Get("/chirps/search", apiCfg.handlerChirpsSearch)
+	apiRouter.With(chirpsRead, optional).Get("/chirps/{chirpID}", apiCfg.handlerChirpsGet)
+	apiRouter.With(chirpsWrite, required).Delete("/chirps/{chirpID}", apiCfg.handlerChirpsDelete)
 	// users
-	apiRouter.Post("/login", apiCfg.handlerLogin)
-	apiRouter.Post("/refresh", apiCfg.handlerRefresh)
-	apiRouter.Post("/revoke", apiCfg.handlerRevoke)
-	apiRouter.Post("/users", apiCfg.handlerUsersCreate)
-	apiRouter.Put("/users", apiCfg.handlerUsersUpdate)
+	apiRouter.With(limitAddress).Post("/login", apiCfg.handlerLogin)
+	apiRouter.With(limitAddress).Post("/login/2fa", apiCfg.handlerLoginTwoFactor)
+	apiRouter.With(limitAddress).Post("/refresh", apiCfg.handlerRefresh)
+	apiRouter.With(limitAddress).Post("/revoke", apiCfg.handlerRevoke)
+	apiRouter.With(limitAddress).Post("/users", apiCfg.handlerUsersCreate)
+	apiRouter.With(required).Put("/users", apiCfg.handlerUsersUpdate)
+	// email verification, posting chirps needs a verified email
+	apiRouter.With(limitAddress).Post("/users/verify", apiCfg.handlerUsersVerify)
+	apiRouter.With(required).Post("/users/verify/resend", apiCfg.handlerUsersVerifyResend)
+	// two-factor authentication with an authenticator app and recovery codes
+	apiRouter.With(required).Post("/users/2fa/enroll", apiCfg.handlerTwoFactorEnroll)
+	apiRouter.With(required).Post("/users/2fa/confirm", apiCfg.handlerTwoFactorConfirm)
+	apiRouter.With(required).Delete("/users/2fa", apiCfg.handlerTwoFactorDisable)
+	// forgotten passwords, reset through a token sent by email
+	apiRouter.With(limitAddress).Post("/password/forgot", apiCfg.handlerPasswordForgot)
+	apiRouter.With(limitAddress).Post("/password/reset", apiCfg.handlerPasswordReset)
+	// api keys of the logged in user, managing them needs an access token
+	apiRouter.With(required).Post("/keys", apiCfg.handlerKeysCreate)
+	apiRouter.With(required).Get("/keys", apiCfg.handlerKeysList)
+	apiRouter.With(required).Delete("/keys/{keyID}", apiCfg.handlerKeysRevoke)
+	// sessions of the logged in user
+	apiRouter.With(required).Get("/sessions", apiCfg.handlerSessionsList)
+	apiRouter.With(required).Delete("/sessions", apiCfg.handlerSessionsRevokeAll)
+	apiRouter.With(required).Delete("/sessions/{sessionID}", apiCfg.handlerSessionsRevoke)
 	// polka webhook
-	apiRouter.Post("/polka/webhooks", apiCfg.handlerWebhook)
+	apiRouter.With(limitAddress).Post("/polka/webhooks", apiCfg.handlerWebhook)
 	router.Mount("/api", apiRouter)
 
 	adminRouter := chi.NewRouter()
-	adminRouter.Get("/metrics", apiCfg.handlerMetrics)
+	// every admin route needs a logged in user, the role depends on the route
+	adminRouter.Use(apiCfg.authn.Required)
+	// counted once the route is matched, so each gets its own limit
+	limitUser := apiCfg.middlewareRateLimitUser
+	requireAdmin := apiCfg.authn.RequireRole(database.RoleAdmin)
+	requireModerator := apiCfg.authn.RequireRole(database.RoleModerator, database.RoleAdmin)
+	adminRouter.With(limitUser, requireAdmin).Get("/metrics", apiCfg.handlerMetrics)
+	adminRouter.With(limitUser, requireAdmin).Put("/users/{userID}/roles", apiCfg.handlerUsersRoles)
+	adminRouter.With(limitUser, requireAdmin).Delete("/users/{userID}/lockout", apiCfg.handlerUsersUnlock)
+	// moderation
+	adminRouter.With(limitUser, requireModerator).Get("/moderation", apiCfg.handlerModerationQueue)
+	adminRouter.With(limitUser, requireModerator).Post("/moderation/{chirpID}/approve", apiCfg.handlerModerationApprove)
+	adminRouter.With(limitUser, requireModerator).Post("/moderation/{chirpID}/reject", apiCfg.handlerModerationReject)
 	router.Mount("/admin", adminRouter)
 
 	corsMux := middlewareCors(router)
@@ -96,3 +242,62 @@ func main() {
 	log.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
 	log.Fatal(server.ListenAndServe())
 }
+
+// jwt keys from the environment, RS256 or EdDSA keys from the JWT_KEY_DIR
+// directory if set, HS256 with JWT_SECRET otherwise
+func jwtKeysFromEnv() (*auth.Keys, error) {
+	keyDir := os.Getenv("JWT_KEY_DIR")
+	if keyDir != "" {
+		// JWT_SIGNING_KID pins the signing key, by default the newest one signs
+		return auth.LoadKeys(keyDir, os.Getenv("JWT_SIGNING_KID"))
+	}
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		return nil, errors.New("JWT_SECRET or JWT_KEY_DIR environment variable must be set")
+	}
+	return auth.NewHMACKeys(jwtSecret), nil
+}
+
+// token lifetimes from the environment, as durations like "15m" or "720h"
+func tokenLifetimesFromEnv() (tokenLifetimes, error) {
+	lifetimes := tokenLifetimes{
+		access:  defaultAccessTokenLifetime,
+		session: defaultSessionLifetime,
+	}
+	for name, lifetime := range map[string]*time.Duration{
+		"ACCESS_TOKEN_LIFETIME":  &lifetimes.access,
+		"REFRESH_TOKEN_LIFETIME": &lifetimes.session,
+	} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return tokenLifetimes{}, fmt.Errorf("%s: %w", name, err)
+		}
+		if parsed <= 0 {
+			return tokenLifetimes{}, fmt.Errorf("%s must be positive", name)
+		}
+		*lifetime = parsed
+	}
+	return lifetimes, nil
+}
+
+// storage backend settings from the environment
+func dbConfigFromEnv() database.Config {
+	dbDriver := os.Getenv("DB_DRIVER")
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = "database.json"
+		if dbDriver == "sqlite" {
+			dbPath = "database.db"
+		}
+	}
+	return database.Config{
+		Driver: dbDriver,
+		Path:   dbPath,
+		// "uuidv7" gives chirps and users an opaque public id as well
+		IDFormat: database.IDFormat(os.Getenv("ID_FORMAT")),
+	}
+}
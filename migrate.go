@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/yuheng-liu/chirpy/internal/database"
+)
+
+// runMigrate - handles "chirpy migrate [-dry-run] [-no-backup]"
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "List pending migrations without applying them")
+	noBackup := flags.Bool("no-backup", false, "Don't copy the database file before migrating")
+	flags.Parse(args)
+
+	dbCfg := dbConfigFromEnv()
+	// sqlite adds its missing columns itself when it is opened
+	if dbCfg.Driver != "" && dbCfg.Driver != "json" {
+		return errors.New("migrate only applies to the json database")
+	}
+	plan, err := database.Migrate(dbCfg.Path, database.MigrateOptions{
+		DryRun:   *dryRun,
+		NoBackup: *noBackup,
+	})
+	if err != nil {
+		return err
+	}
+	// report what was, or with -dry-run would be, done
+	if !plan.NeedsMigration() {
+		fmt.Printf("%s is up to date at schema version %d\n", dbCfg.Path, plan.From)
+		return nil
+	}
+	for _, step := range plan.Steps {
+		fmt.Println(step)
+	}
+	if *dryRun {
+		fmt.Printf("dry run: %s would be migrated from version %d to %d\n", dbCfg.Path, plan.From, plan.To)
+		return nil
+	}
+	if plan.BackupPath != "" {
+		fmt.Printf("backed up %s to %s\n", dbCfg.Path, plan.BackupPath)
+	}
+	fmt.Printf("migrated %s from version %d to %d\n", dbCfg.Path, plan.From, plan.To)
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// page size when the request has no limit parameter
+	defaultPageLimit = 100
+	// largest page size a client can ask for
+	maxPageLimit = 1000
+)
+
+// position in a listing, handed to clients as an opaque string
+type pageCursor struct {
+	// sort order the cursor was issued for, it is only valid for the same order
+	Sort string `json:"sort"`
+	// id of the last item on the previous page
+	ID int `json:"id"`
+	// creation time of the last item, for orderings by created_at
+	CreatedAt time.Time `json:"created_at"`
+	// relevance of the last item, for search results
+	Score float64 `json:"score,omitempty"`
+}
+
+func encodeCursor(cursor pageCursor) string {
+	dat, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(dat)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	cursor := pageCursor{}
+	dat, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, errors.New("Invalid cursor")
+	}
+	err = json.Unmarshal(dat, &cursor)
+	if err != nil || cursor.ID <= 0 {
+		return cursor, errors.New("Invalid cursor")
+	}
+	return cursor, nil
+}
+
+// read the limit query parameter, capped at maxPageLimit
+func parsePageLimit(r *http.Request) (int, error) {
+	limitString := r.URL.Query().Get("limit")
+	if limitString == "" {
+		return defaultPageLimit, nil
+	}
+	limit, err := strconv.Atoi(limitString)
+	if err != nil || limit <= 0 {
+		return 0, errors.New("Invalid limit")
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	return limit, nil
+}
+
+// read the cursor query parameter, checking it belongs to the requested sort order
+func parsePageCursor(r *http.Request, sortOrder string) (pageCursor, error) {
+	cursorString := r.URL.Query().Get("cursor")
+	if cursorString == "" {
+		return pageCursor{}, nil
+	}
+	cursor, err := decodeCursor(cursorString)
+	if err != nil {
+		return cursor, err
+	}
+	if cursor.Sort != sortOrder {
+		return cursor, errors.New("Cursor doesn't match sort order")
+	}
+	return cursor, nil
+}
+
+// point the client at the next page with a Link header, keeping the other query parameters
+func setNextPageLink(w http.ResponseWriter, r *http.Request, cursor pageCursor) {
+	query := r.URL.Query()
+	query.Set("cursor", encodeCursor(cursor))
+	next := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+}
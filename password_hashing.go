@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/yuheng-liu/chirpy/internal/auth"
+	"github.com/yuheng-liu/chirpy/internal/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// how new passwords are hashed, PASSWORD_HASHER is "bcrypt" (default) with
+// BCRYPT_COST or "argon2id" with ARGON2_MEMORY_KIB, ARGON2_ITERATIONS and
+// ARGON2_PARALLELISM. Hashes from the other algorithm or weaker settings still
+// work and are upgraded on the next login
+func passwordsFromEnv() (*auth.Passwords, error) {
+	cost, err := positiveIntFromEnv("BCRYPT_COST", bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	memory, err := positiveIntFromEnv("ARGON2_MEMORY_KIB", int(auth.DefaultArgon2id.Memory))
+	if err != nil {
+		return nil, err
+	}
+	iterations, err := positiveIntFromEnv("ARGON2_ITERATIONS", int(auth.DefaultArgon2id.Iterations))
+	if err != nil {
+		return nil, err
+	}
+	parallelism, err := positiveIntFromEnv("ARGON2_PARALLELISM", int(auth.DefaultArgon2id.Parallelism))
+	if err != nil {
+		return nil, err
+	}
+	if parallelism > 255 {
+		return nil, errors.New("ARGON2_PARALLELISM must be at most 255")
+	}
+
+	bcryptHasher := auth.BcryptHasher{Cost: cost}
+	argon2idHasher := auth.DefaultArgon2id
+	argon2idHasher.Memory = uint32(memory)
+	argon2idHasher.Iterations = uint32(iterations)
+	argon2idHasher.Parallelism = uint8(parallelism)
+
+	switch hasher := os.Getenv("PASSWORD_HASHER"); hasher {
+	case "", "bcrypt":
+		return auth.NewPasswords(bcryptHasher, argon2idHasher), nil
+	case "argon2id":
+		return auth.NewPasswords(argon2idHasher, bcryptHasher), nil
+	default:
+		return nil, fmt.Errorf("unknown PASSWORD_HASHER %q", hasher)
+	}
+}
+
+func positiveIntFromEnv(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 1 {
+		return 0, fmt.Errorf("%s must be a positive number", name)
+	}
+	return parsed, nil
+}
+
+// replace the user's password hash with one made with the current settings,
+// the login goes ahead with the old hash if this fails
+func (cfg *apiConfig) rehashPassword(user database.User, password string) {
+	hashedPassword, err := cfg.passwords.Hash(password)
+	if err != nil {
+		log.Printf("Couldn't rehash password of user %d: %s", user.ID, err)
+		return
+	}
+	err = cfg.DB.RehashPassword(user.ID, user.HashedPassword, hashedPassword)
+	if err != nil {
+		log.Printf("Couldn't store rehashed password of user %d: %s", user.ID, err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/yuheng-liu/chirpy/internal/auth"
+)
+
+// shortest password allowed unless PASSWORD_MIN_LENGTH says otherwise
+const defaultPasswordMinLength = 8
+
+// rules for new passwords from the environment, PASSWORD_BANNED_FILE adds to
+// the bundled common passwords and PASSWORD_BREACH_DIR turns on the check
+// against local Pwned Passwords range files
+func passwordPolicyFromEnv() (*auth.PasswordPolicy, error) {
+	minLength := defaultPasswordMinLength
+	if value := os.Getenv("PASSWORD_MIN_LENGTH"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return nil, errors.New("PASSWORD_MIN_LENGTH must be a positive number")
+		}
+		minLength = parsed
+	}
+	var breaches auth.BreachChecker
+	if dir := os.Getenv("PASSWORD_BREACH_DIR"); dir != "" {
+		rangeFiles, err := auth.NewRangeFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+		breaches = rangeFiles
+	}
+	policy := auth.NewPasswordPolicy(minLength, breaches)
+	if path := os.Getenv("PASSWORD_BANNED_FILE"); path != "" {
+		err := policy.BanPasswordsFromFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// check a new password against the policy, responding with every rule it
+// broke if it isn't allowed, returns whether the password can be used
+func (cfg *apiConfig) checkPassword(w http.ResponseWriter, password string) bool {
+	type response struct {
+		Error      string                   `json:"error"`
+		Violations []auth.PasswordViolation `json:"violations"`
+	}
+	err := cfg.passwordPolicy.Validate(password)
+	if err == nil {
+		return true
+	}
+	policyErr := &auth.PasswordPolicyError{}
+	if errors.As(err, &policyErr) {
+		respondWithJSON(w, http.StatusBadRequest, response{
+			Error:      "Password doesn't meet the requirements",
+			Violations: policyErr.Violations,
+		})
+		return false
+	}
+	respondWithError(w, http.StatusInternalServerError, "Couldn't check password")
+	return false
+}
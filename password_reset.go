@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/yuheng-liu/chirpy/internal/database"
+	"github.com/yuheng-liu/chirpy/internal/mail"
+)
+
+// how long the token in a password reset email works
+const passwordResetLifetime = 30 * time.Minute
+
+// send the user a token they can set a new password with
+func (cfg *apiConfig) sendPasswordResetEmail(user database.User, token string) error {
+	return cfg.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf(`Someone asked to reset the password of your Chirpy account.
+
+Choose a new password by sending the token below to
+POST %s/api/password/reset as {"token": "...", "password": "..."}:
+
+%s
+
+The token works once and expires in %s. Resetting logs you out everywhere.
+If you didn't ask for this, ignore this email and your password stays the same.
+`, cfg.publicURL, token, passwordResetLifetime),
+	})
+}
+
+// remove password reset tokens that expired more than a day ago every interval
+func prunePasswordResets(db database.Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		removed, err := db.DeleteInactivePasswordResets(time.Now().UTC().Add(-24 * time.Hour))
+		if err != nil {
+			log.Printf("Couldn't prune password reset tokens: %s", err)
+			continue
+		}
+		if removed > 0 {
+			log.Printf("Pruned %d expired password reset tokens", removed)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yuheng-liu/chirpy/internal/auth"
+	"github.com/yuheng-liu/chirpy/internal/database"
+	"github.com/yuheng-liu/chirpy/internal/mail"
+)
+
+// reset tokens are 32 random bytes in hex
+var resetTokenPattern = regexp.MustCompile(`\b[0-9a-f]{64}\b`)
+
+func newTestResetConfig(t *testing.T, mailDir string) *apiConfig {
+	t.Helper()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	mailer, err := mail.NewFileMailer(mailDir, "no-reply@localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwtKeys := auth.NewHMACKeys("test secret")
+	denylist := auth.NewDenylist(100)
+	return &apiConfig{
+		DB:             db,
+		jwtKeys:        jwtKeys,
+		denylist:       denylist,
+		authn:          auth.NewAuthenticator(jwtKeys, denylist, db, respondWithError),
+		lockout:        auth.NewLockout(auth.NewMemoryAttempts(100), auth.DefaultAccountPolicy, auth.DefaultAddressPolicy),
+		mailer:         mailer,
+		passwordPolicy: auth.NewPasswordPolicy(defaultPasswordMinLength, nil),
+		passwords:      auth.NewPasswords(auth.BcryptHasher{Cost: 4}),
+		publicURL:      "http://localhost:8080",
+	}
+}
+
+func post(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	return w
+}
+
+// the email is sent in the background, wait for it to show up
+func waitForResetToken(t *testing.T, mailDir string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		files, err := filepath.Glob(filepath.Join(mailDir, "*.eml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) > 0 {
+			dat, err := os.ReadFile(files[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the file may still be being written
+			if token := resetTokenPattern.Find(dat); token != nil {
+				return string(token)
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no password reset email with a token was sent")
+	return ""
+}
+
+func TestPasswordResetTokenWorksOnce(t *testing.T) {
+	mailDir := t.TempDir()
+	cfg := newTestResetConfig(t, mailDir)
+	hashedPassword, err := cfg.passwords.Hash("the old passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := cfg.DB.CreateUser("a@example.com", hashedPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cfg.DB.CreateSession(database.Session{UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}, "refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := post(cfg.handlerPasswordForgot, `{"email":"a@example.com"}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("forgot: status %d, want 202: %s", w.Code, w.Body)
+	}
+	token := waitForResetToken(t, mailDir)
+
+	body := `{"token":"` + token + `","password":"a brand new passphrase"}`
+	w = post(cfg.handlerPasswordReset, body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("reset: status %d, want 200: %s", w.Code, w.Body)
+	}
+	user, err = cfg.DB.GetUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.passwords.Check("a brand new passphrase", user.HashedPassword); err != nil {
+		t.Errorf("new password doesn't work: %v", err)
+	}
+	// the reset logged out every session
+	sessions, err := cfg.DB.ListActiveSessions(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("%d sessions still active after reset", len(sessions))
+	}
+
+	// the same token can't be used again, even with another password
+	w = post(cfg.handlerPasswordReset, `{"token":"`+token+`","password":"yet another passphrase"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("second reset: status %d, want 400: %s", w.Code, w.Body)
+	}
+	user, err = cfg.DB.GetUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.passwords.Check("a brand new passphrase", user.HashedPassword); err != nil {
+		t.Errorf("second reset changed the password: %v", err)
+	}
+}
+
+func TestPasswordResetRevokesAPIKeys(t *testing.T) {
+	mailDir := t.TempDir()
+	cfg := newTestResetConfig(t, mailDir)
+	user, err := cfg.DB.CreateUser("a@example.com", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, prefix, err := auth.MakeAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cfg.DB.CreateAPIKey(database.APIKey{
+		UserID: user.ID,
+		Name:   "made by whoever took over the account",
+		Prefix: prefix,
+		Hash:   auth.HashAPIKey(key),
+		Scopes: []database.Scope{database.ScopeChirpsRead},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a route taking api keys, like GET /api/chirps but requiring a user
+	protected := cfg.authn.AllowAPIKeys(database.ScopeChirpsRead)(cfg.authn.Required(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, struct{}{})
+	})))
+	useKey := func() int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "ApiKey "+key)
+		protected.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := useKey(); code != http.StatusOK {
+		t.Fatalf("key before reset: status %d, want 200", code)
+	}
+
+	post(cfg.handlerPasswordForgot, `{"email":"a@example.com"}`)
+	token := waitForResetToken(t, mailDir)
+	w := post(cfg.handlerPasswordReset, `{"token":"`+token+`","password":"a brand new passphrase"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("reset: status %d, want 200: %s", w.Code, w.Body)
+	}
+	if code := useKey(); code != http.StatusUnauthorized {
+		t.Errorf("key after reset: status %d, want 401", code)
+	}
+}
+
+func TestPasswordForgotUnknownEmail(t *testing.T) {
+	mailDir := t.TempDir()
+	cfg := newTestResetConfig(t, mailDir)
+	// same answer as for an existing account
+	w := post(cfg.handlerPasswordForgot, `{"email":"nobody@example.com"}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want 202: %s", w.Code, w.Body)
+	}
+	time.Sleep(50 * time.Millisecond)
+	files, err := os.ReadDir(mailDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("%d emails sent for an unknown address", len(files))
+	}
+}
+
+func TestPasswordResetInvalidToken(t *testing.T) {
+	cfg := newTestResetConfig(t, t.TempDir())
+	w := post(cfg.handlerPasswordReset, `{"token":"not-a-token","password":"a brand new passphrase"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400: %s", w.Code, w.Body)
+	}
+}
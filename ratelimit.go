@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/yuheng-liu/chirpy/internal/auth"
+	"github.com/yuheng-liu/chirpy/internal/ratelimit"
+)
+
+// middlewareRateLimitAddress - limits requests with the policy of their route,
+// counted per address, for routes that don't authenticate
+func (cfg *apiConfig) middlewareRateLimitAddress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.allowRequest(w, r, "ip:"+clientIP(r), false) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// middlewareRateLimitUser - limits requests with the policy of their route,
+// counted per user, goes after authn.Required or authn.Optional. Anonymous
+// requests are counted per address
+func (cfg *apiConfig) middlewareRateLimitUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, red := "ip:"+clientIP(r), false
+		// the user was loaded by the auth middleware, so membership is current
+		if user, ok := auth.UserFromContext(r.Context()); ok {
+			client, red = "user:"+strconv.Itoa(user.ID), user.IsChirpyRed
+		}
+		if cfg.allowRequest(w, r, client, red) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// take the request from the client's bucket for the route, answers with 429
+// and returns false if it's empty
+func (cfg *apiConfig) allowRequest(w http.ResponseWriter, r *http.Request, client string, red bool) bool {
+	// the router matched the route already, every route gets its own buckets
+	route, policy := cfg.rateLimits.Policy(r.Method, chi.RouteContext(r.Context()).RoutePattern())
+	if policy.Limit == 0 {
+		return true
+	}
+	decision := cfg.limiter.Allow(route+"|"+client, policy.LimitFor(red), time.Duration(policy.Window))
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+	if !decision.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(decision.RetryAfter)))
+		respondWithError(w, http.StatusTooManyRequests, "Too many requests, try again later")
+		return false
+	}
+	return true
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// drop buckets that refilled every interval
+func pruneRateLimiter(limiter *ratelimit.Limiter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		limiter.Prune()
+	}
+}
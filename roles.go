@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/yuheng-liu/chirpy/internal/database"
+)
+
+// runRoles - handles "chirpy roles -email <email> [-set role,role]", prints the
+// user's roles or replaces them, so the first admin can be made from the shell
+func runRoles(args []string) error {
+	flags := flag.NewFlagSet("roles", flag.ExitOnError)
+	email := flags.String("email", "", "Email of the user")
+	set := flags.String("set", "", "Comma separated roles to give the user, e.g. admin,moderator")
+	flags.Parse(args)
+	if *email == "" {
+		return errors.New("roles needs -email")
+	}
+
+	db, err := database.NewStore(dbConfigFromEnv())
+	if errors.Is(err, database.ErrLocked) {
+		return fmt.Errorf("%w, stop the server or use PUT /admin/users/{userID}/roles instead", err)
+	}
+	if err != nil {
+		return err
+	}
+	user, err := db.GetUserByEmail(*email)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *email, err)
+	}
+	if *set != "" {
+		roles, err := parseRoles(strings.Split(*set, ","))
+		if err != nil {
+			return err
+		}
+		user, err = db.SetUserRoles(user.ID, roles)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%s: %s\n", user.Email, strings.Join(roleNames(user.Roles), ", "))
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/yuheng-liu/chirpy/internal/auth"
+	"github.com/yuheng-liu/chirpy/internal/database"
+)
+
+// token lifetimes used unless configured otherwise
+const (
+	defaultAccessTokenLifetime = time.Hour
+	defaultSessionLifetime     = time.Hour * 24 * 30 * 6
+)
+
+// how long an exchanged refresh token is remembered, presenting it again
+// within the window revokes its session as the token probably leaked
+const refreshReuseWindow = 24 * time.Hour
+
+// tokenLifetimes - how long issued tokens stay valid
+type tokenLifetimes struct {
+	// longest an access token may be valid, clients can ask for less on login,
+	// also how long a revoked one is denylisted
+	access time.Duration
+	// how long a login lasts, refreshing rotates the token but doesn't extend it
+	session time.Duration
+}
+
+// access token lifetime for a client that asked for requestedSeconds, 0 or
+// less gets the maximum
+func (lifetimes tokenLifetimes) accessFor(requestedSeconds int) time.Duration {
+	requested := time.Duration(requestedSeconds) * time.Second
+	if requested <= 0 || requested > lifetimes.access {
+		return lifetimes.access
+	}
+	return requested
+}
+
+// start a session for the user logged in by r, returns its first refresh token
+func (cfg *apiConfig) startSession(r *http.Request, user database.User) (string, database.Session, error) {
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", database.Session{}, err
+	}
+	session, err := cfg.DB.CreateSession(database.Session{
+		UserID:    user.ID,
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+		ExpiresAt: time.Now().UTC().Add(cfg.lifetimes.session),
+	}, auth.HashRefreshToken(refreshToken))
+	if err != nil {
+		return "", database.Session{}, err
+	}
+	return refreshToken, session, nil
+}
+
+// block the access tokens already issued for the session, none of them can
+// outlive the access token lifetime
+func (cfg *apiConfig) denylistSession(sessionID int) {
+	cfg.denylist.RevokeSession(sessionID, time.Now().Add(cfg.lifetimes.access))
+}
+
+// address the request came from, without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// remove sessions that ended and refresh tokens exchanged more than the reuse
+// window ago every interval, until then a reused token is still reported
+func pruneSessions(db database.Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cutoff := time.Now().UTC().Add(-refreshReuseWindow)
+		removed, err := db.DeleteInactiveSessions(cutoff)
+		if err != nil {
+			log.Printf("Couldn't prune sessions: %s", err)
+			continue
+		}
+		if removed > 0 {
+			log.Printf("Pruned %d inactive sessions", removed)
+		}
+		removed, err = db.DeleteRotatedRefreshTokens(cutoff)
+		if err != nil {
+			log.Printf("Couldn't prune refresh tokens: %s", err)
+			continue
+		}
+		if removed > 0 {
+			log.Printf("Pruned %d exchanged refresh tokens", removed)
+		}
+	}
+}
+
+// drop expired denylist entries every interval, so memory is freed even
+// while nothing new is revoked
+func pruneDenylist(denylist *auth.Denylist, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		denylist.Prune()
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/yuheng-liu/chirpy/internal/auth"
+	"github.com/yuheng-liu/chirpy/internal/database"
+)
+
+const (
+	// how long after the password the second factor has to be given
+	mfaChallengeLifetime = 5 * time.Minute
+	// recovery codes handed out when two-factor authentication is turned on
+	recoveryCodeCount = 10
+	// name authenticator apps list the account under
+	totpIssuer = "Chirpy"
+)
+
+var errInvalidCode = errors.New("invalid code")
+
+// check a code from the user's authenticator app, or one of their recovery
+// codes if given instead, using it up so it can't be replayed.
+// errInvalidCode if it is wrong or was used before
+func (cfg *apiConfig) useSecondFactor(user database.User, code, recoveryCode string) error {
+	if recoveryCode != "" {
+		err := cfg.DB.UseRecoveryCode(user.ID, auth.HashRecoveryCode(recoveryCode))
+		if errors.Is(err, database.ErrCodeUsed) {
+			return errInvalidCode
+		}
+		return err
+	}
+	step, err := auth.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if errors.Is(err, auth.ErrInvalidTOTPCode) {
+		return errInvalidCode
+	}
+	if err != nil {
+		return err
+	}
+	err = cfg.DB.UseTOTPStep(user.ID, step)
+	if errors.Is(err, database.ErrCodeUsed) {
+		return errInvalidCode
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	netmail "net/mail"
+	"os"
+	"time"
+
+	"github.com/yuheng-liu/chirpy/internal/auth"
+	"github.com/yuheng-liu/chirpy/internal/database"
+	"github.com/yuheng-liu/chirpy/internal/mail"
+)
+
+// how long the token in a verification email works
+const emailVerificationLifetime = 24 * time.Hour
+
+// how emails are sent, MAILER is "log" to print them, "file" to write them to
+// MAIL_DIR or "smtp" to deliver them through SMTP_ADDR. Printed emails carry
+// working tokens, so "log" is only the default in debug mode
+func mailerFromEnv(debug bool) (mail.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@localhost"
+	}
+	switch mailer := os.Getenv("MAILER"); mailer {
+	case "":
+		if !debug {
+			return nil, errors.New("MAILER environment variable must be set, MAILER=log prints emails with their tokens to the server log")
+		}
+		return mail.LogMailer{}, nil
+	case "log":
+		if !debug {
+			log.Print("WARNING: MAILER=log prints verification and password reset tokens to the server log, anyone who can read it can take over accounts")
+		}
+		return mail.LogMailer{}, nil
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return mail.NewFileMailer(dir, from)
+	case "smtp":
+		addr := os.Getenv("SMTP_ADDR")
+		if addr == "" {
+			return nil, errors.New("SMTP_ADDR environment variable must be set for MAILER=smtp")
+		}
+		return mail.NewSMTPMailer(addr, from, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q", mailer)
+	}
+}
+
+// whether s is a bare email address, without a display name
+func validEmail(s string) bool {
+	address, err := netmail.ParseAddress(s)
+	return err == nil && address.Address == s
+}
+
+// send the user a token proving they own their email
+func (cfg *apiConfig) sendVerificationEmail(user database.User) error {
+	token, err := auth.MakeEmailVerificationToken(user.ID, user.Email, cfg.jwtKeys, emailVerificationLifetime)
+	if err != nil {
+		return err
+	}
+	return cfg.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf(`Welcome to Chirpy!
+
+Confirm this is your email address by sending the token below to
+POST %s/api/users/verify as {"token": "..."}:
+
+%s
+
+The token expires in %s. If you didn't sign up for Chirpy, ignore this email.
+`, cfg.publicURL, token, emailVerificationLifetime),
+	})
+}